@@ -0,0 +1,328 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+type CircuitOpenError struct {
+	Key        string
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	if e.Key == "" {
+		return "Circuit open"
+	}
+	return fmt.Sprintf("Circuit open for %s", e.Key)
+}
+
+func IsCircuitOpen(err error) bool {
+	var e *CircuitOpenError
+	return errors.As(err, &e)
+}
+
+// The circuit opens when, within the rolling window, the failure ratio
+// reaches FailureRatio (given at least MinRequests), or on ConsecutiveFailures.
+// After CoolDown, up to HalfOpenProbes requests are let through; it closes
+// once they all succeed and opens again on any failure.
+type CircuitBreakerOptions struct {
+	Window              time.Duration // default 10s
+	Buckets             int           // default 10
+	MinRequests         int           // default 10
+	FailureRatio        float64       // default 0.5, <0 to disable
+	ConsecutiveFailures int           // default 5, <0 to disable
+	CoolDown            time.Duration // default 30s
+	HalfOpenProbes      int           // default 1
+
+	IsFailure     func(res *http.Response, err error) bool // default: error or 5xx
+	OnStateChange func(key string, from, to CircuitState)
+	Now           func() time.Time
+}
+
+func (opts CircuitBreakerOptions) withDefaults() CircuitBreakerOptions {
+	if opts.Window <= 0 {
+		opts.Window = 10 * time.Second
+	}
+	if opts.Buckets <= 0 {
+		opts.Buckets = 10
+	}
+	if opts.MinRequests <= 0 {
+		opts.MinRequests = 10
+	}
+	if opts.FailureRatio == 0 {
+		opts.FailureRatio = 0.5
+	}
+	if opts.ConsecutiveFailures == 0 {
+		opts.ConsecutiveFailures = 5
+	}
+	if opts.CoolDown <= 0 {
+		opts.CoolDown = 30 * time.Second
+	}
+	if opts.HalfOpenProbes <= 0 {
+		opts.HalfOpenProbes = 1
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = defaultIsFailure
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return opts
+}
+
+func defaultIsFailure(res *http.Response, err error) bool {
+	return err != nil || res == nil || res.StatusCode >= 500
+}
+
+type cbBucket struct {
+	start     time.Time
+	successes int
+	failures  int
+}
+
+type CircuitBreaker struct {
+	key  string
+	opts CircuitBreakerOptions
+
+	mu          sync.Mutex
+	state       CircuitState
+	openedAt    time.Time
+	buckets     []cbBucket
+	consecutive int
+	probes      int // in flight
+	probeOks    int
+	generation  uint64
+	changes     []cbChange // reported by unlock
+}
+
+type cbChange struct {
+	from, to CircuitState
+}
+
+func NewCircuitBreaker(key string, opts CircuitBreakerOptions) *CircuitBreaker {
+	opts = opts.withDefaults()
+	return &CircuitBreaker{
+		key:     key,
+		opts:    opts,
+		buckets: make([]cbBucket, opts.Buckets),
+	}
+}
+
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.unlock()
+	cb.checkCoolDown(cb.opts.Now())
+	return cb.state
+}
+
+// Allow returns a callback to report the outcome, or a *CircuitOpenError
+func (cb *CircuitBreaker) Allow() (func(failed bool), error) {
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	now := cb.opts.Now()
+	cb.checkCoolDown(now)
+
+	switch cb.state {
+	case CircuitOpen:
+		return nil, &CircuitOpenError{cb.key, cb.openedAt.Add(cb.opts.CoolDown).Sub(now)}
+	case CircuitHalfOpen:
+		if cb.probes >= cb.opts.HalfOpenProbes {
+			return nil, &CircuitOpenError{Key: cb.key}
+		}
+		cb.probes++
+	}
+
+	gen := cb.generation
+	return func(failed bool) { cb.record(gen, failed) }, nil
+}
+
+// Do runs fn if allowed, counting a non-nil error as failure
+func (cb *CircuitBreaker) Do(fn func() error) error {
+	done, err := cb.Allow()
+	if err != nil {
+		return err
+	}
+	err = fn()
+	done(err != nil)
+	return err
+}
+
+func (cb *CircuitBreaker) record(gen uint64, failed bool) {
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	if gen != cb.generation { // stale outcome from a previous state
+		return
+	}
+
+	now := cb.opts.Now()
+	switch cb.state {
+	case CircuitHalfOpen:
+		cb.probes--
+		if failed {
+			cb.setState(CircuitOpen, now)
+		} else if cb.probeOks++; cb.probeOks >= cb.opts.HalfOpenProbes {
+			cb.setState(CircuitClosed, now)
+		}
+		return
+	case CircuitOpen:
+		return
+	}
+
+	b := cb.bucket(now)
+	if failed {
+		b.failures++
+		cb.consecutive++
+	} else {
+		b.successes++
+		cb.consecutive = 0
+	}
+
+	if failed && cb.shouldTrip(now) {
+		cb.setState(CircuitOpen, now)
+	}
+}
+
+func (cb *CircuitBreaker) shouldTrip(now time.Time) bool {
+	if n := cb.opts.ConsecutiveFailures; n > 0 && cb.consecutive >= n {
+		return true
+	}
+	if cb.opts.FailureRatio < 0 {
+		return false
+	}
+	var total, failures int
+	for _, b := range cb.buckets {
+		if now.Sub(b.start) < cb.opts.Window {
+			total += b.successes + b.failures
+			failures += b.failures
+		}
+	}
+	return total >= cb.opts.MinRequests && float64(failures)/float64(total) >= cb.opts.FailureRatio
+}
+
+func (cb *CircuitBreaker) bucket(now time.Time) *cbBucket {
+	width := cb.opts.Window / time.Duration(len(cb.buckets))
+	if width <= 0 {
+		width = 1
+	}
+	slot := now.UnixNano() / int64(width)
+	b := &cb.buckets[slot%int64(len(cb.buckets))]
+	if start := time.Unix(0, slot*int64(width)); !b.start.Equal(start) {
+		*b = cbBucket{start: start}
+	}
+	return b
+}
+
+func (cb *CircuitBreaker) checkCoolDown(now time.Time) {
+	if cb.state == CircuitOpen && now.Sub(cb.openedAt) >= cb.opts.CoolDown {
+		cb.setState(CircuitHalfOpen, now)
+	}
+}
+
+func (cb *CircuitBreaker) setState(state CircuitState, now time.Time) {
+	from := cb.state
+	cb.state = state
+	cb.generation++
+	cb.probes, cb.probeOks = 0, 0
+	switch state {
+	case CircuitOpen:
+		cb.openedAt = now
+	case CircuitClosed:
+		cb.consecutive = 0
+		for i := range cb.buckets {
+			cb.buckets[i] = cbBucket{}
+		}
+	}
+	if from != state {
+		cb.changes = append(cb.changes, cbChange{from, state})
+	}
+}
+
+// unlock reports state changes after releasing the lock, so that
+// OnStateChange may call back into the breaker
+func (cb *CircuitBreaker) unlock() {
+	changes := cb.changes
+	cb.changes = nil
+	cb.mu.Unlock()
+	if cb.opts.OnStateChange != nil {
+		for _, c := range changes {
+			cb.opts.OnStateChange(cb.key, c.from, c.to)
+		}
+	}
+}
+
+// CircuitBreakerTransport keeps one breaker per host, failing fast with
+// *CircuitOpenError while the host circuit is open, e.g.
+//
+//	HttpClient = &http.Client{Transport: NewCircuitBreakerTransport(opts, nil)}
+type CircuitBreakerTransport struct {
+	Transport http.RoundTripper
+	opts      CircuitBreakerOptions
+
+	mu       sync.Mutex
+	breakers map[string]*CircuitBreaker
+}
+
+func NewCircuitBreakerTransport(opts CircuitBreakerOptions, transport http.RoundTripper) *CircuitBreakerTransport {
+	return &CircuitBreakerTransport{
+		Transport: transport,
+		opts:      opts.withDefaults(),
+		breakers:  map[string]*CircuitBreaker{},
+	}
+}
+
+func (t *CircuitBreakerTransport) Breaker(host string) *CircuitBreaker {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cb, ok := t.breakers[host]
+	if !ok {
+		cb = NewCircuitBreaker(host, t.opts)
+		t.breakers[host] = cb
+	}
+	return cb
+}
+
+func (t *CircuitBreakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	done, err := t.Breaker(req.URL.Host).Allow()
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	res, err := transport.RoundTrip(req)
+	done(t.opts.IsFailure(res, err))
+	return res, err
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	var changes []string
+	var cb *CircuitBreaker
+	cb = NewCircuitBreaker("svc", CircuitBreakerOptions{
+		ConsecutiveFailures: 3,
+		FailureRatio:        -1,
+		CoolDown:            time.Minute,
+		HalfOpenProbes:      2,
+		Now:                 func() time.Time { return now },
+		OnStateChange: func(key string, from, to CircuitState) {
+			// calling back into the breaker must not deadlock
+			changes = append(changes, fmt.Sprintf("%s:%s->%s=%s", key, from, to, cb.State()))
+		},
+	})
+	fail := errors.New("fail")
+
+	for i := 0; i < 3; i++ {
+		if cb.State() != CircuitClosed {
+			t.Fatalf("should be closed after %d failures", i)
+		}
+		cb.Do(func() error { return fail })
+	}
+	if cb.State() != CircuitOpen {
+		t.Fatal("should open on consecutive failures")
+	}
+	err := cb.Do(func() error { t.Fatal("should not run while open"); return nil })
+	var openErr *CircuitOpenError
+	if !errors.As(err, &openErr) || openErr.RetryAfter != time.Minute {
+		t.Fatalf("expected open error, got %v", err)
+	}
+
+	// half-open after cool down, reopening on a failed probe
+	now = now.Add(time.Minute)
+	if cb.State() != CircuitHalfOpen {
+		t.Fatal("should be half-open after cool down")
+	}
+	cb.Do(func() error { return fail })
+	if cb.State() != CircuitOpen {
+		t.Fatal("a failed probe should reopen")
+	}
+
+	// limited probes, closing once all succeed
+	now = now.Add(time.Minute)
+	done1, err1 := cb.Allow()
+	done2, err2 := cb.Allow()
+	if _, err := cb.Allow(); err1 != nil || err2 != nil || !IsCircuitOpen(err) {
+		t.Fatalf("expected 2 probes, got %v %v %v", err1, err2, err)
+	}
+	done1(false)
+	if cb.State() != CircuitHalfOpen {
+		t.Fatal("should wait for all probes")
+	}
+	done2(false)
+	if cb.State() != CircuitClosed {
+		t.Fatal("should close once probes succeed")
+	}
+
+	want := []string{
+		"svc:closed->open=open",
+		"svc:open->half-open=half-open",
+		"svc:half-open->open=open",
+		"svc:open->half-open=half-open",
+		"svc:half-open->closed=closed",
+	}
+	if fmt.Sprint(changes) != fmt.Sprint(want) {
+		t.Fatalf("bad state changes %v", changes)
+	}
+}
+
+func TestCircuitBreakerRatio(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	cb := NewCircuitBreaker("", CircuitBreakerOptions{
+		Window:              10 * time.Second,
+		MinRequests:         4,
+		ConsecutiveFailures: -1,
+		Now:                 func() time.Time { return now },
+	})
+	outcome := func(failed bool) {
+		done, err := cb.Allow()
+		if err != nil {
+			t.Fatal(err)
+		}
+		done(failed)
+	}
+
+	outcome(true)
+	outcome(false)
+	outcome(true)
+	if cb.State() != CircuitClosed {
+		t.Fatal("should wait for MinRequests")
+	}
+	// earlier outcomes roll out of the window
+	now = now.Add(11 * time.Second)
+	outcome(false)
+	outcome(false)
+	outcome(false)
+	outcome(true)
+	if cb.State() != CircuitClosed {
+		t.Fatal("ratio below threshold should stay closed")
+	}
+	outcome(true)
+	outcome(true)
+	if cb.State() != CircuitOpen {
+		t.Fatal("should open at the failure ratio")
+	}
+
+	// tiny windows still work
+	tiny := NewCircuitBreaker("", CircuitBreakerOptions{Window: 5, Buckets: 10})
+	tiny.Do(func() error { return nil })
+}
+
+func TestCircuitBreakerTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: NewCircuitBreakerTransport(CircuitBreakerOptions{ConsecutiveFailures: 2}, nil)}
+	for i := 0; i < 2; i++ {
+		res, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	if _, err := client.Get(srv.URL); !IsCircuitOpen(err) {
+		t.Fatalf("expected open circuit, got %v", err)
+	}
+}
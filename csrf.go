@@ -0,0 +1,205 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	neturl "net/url"
+	"strings"
+)
+
+type CsrfMode int
+
+const (
+	CsrfDoubleSubmit CsrfMode = iota // token in a cookie, echoed in header or form
+	CsrfSynchronizer                 // token in session, needs SessionManager.Middleware first
+)
+
+const csrfSessionKey = "_csrf"
+
+type CsrfOptions struct {
+	Mode           CsrfMode
+	CookieName     string      // default "csrf_token"
+	HeaderName     string      // default "X-CSRF-Token"
+	FieldName      string      // default "csrf_token"
+	Cookie         http.Cookie // template for Path, Domain, Secure, SameSite etc
+	TrustedOrigins []string    // hosts allowed besides the request host
+	PlainError     bool        // text/plain instead of JsonMsg errors
+	ErrorHandler   http.Handler
+
+	// Key signs double-submit cookies, so that cookies planted e.g. from a
+	// subdomain are rejected; a random key is used if empty, which does not
+	// survive restarts nor work across instances
+	Key []byte
+}
+
+func (opts CsrfOptions) withDefaults() CsrfOptions {
+	if opts.CookieName == "" {
+		opts.CookieName = "csrf_token"
+	}
+	if opts.HeaderName == "" {
+		opts.HeaderName = "X-CSRF-Token"
+	}
+	if opts.FieldName == "" {
+		opts.FieldName = "csrf_token"
+	}
+	if opts.Cookie.Path == "" {
+		opts.Cookie.Path = "/"
+	}
+	if opts.Cookie.SameSite == 0 {
+		opts.Cookie.SameSite = http.SameSiteLaxMode
+	}
+	return opts
+}
+
+type csrfContextKey struct{}
+
+// CsrfToken returns the token to embed in forms or pass to scripts
+func CsrfToken(req *http.Request) string {
+	token, _ := req.Context().Value(csrfContextKey{}).(string)
+	return token
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func CsrfMiddleware(opts CsrfOptions) Middleware {
+	opts = opts.withDefaults()
+	if len(opts.Key) == 0 && opts.Mode == CsrfDoubleSubmit {
+		key, err := CrandBytes(32)
+		if err != nil {
+			panic(err)
+		}
+		opts.Key = key
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			token, err := csrfCurrentToken(w, req, &opts)
+			if err != nil {
+				csrfFail(w, req, &opts, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			if !isSafeMethod(req.Method) {
+				if msg := csrfCheckOrigin(req, &opts); msg != "" {
+					csrfFail(w, req, &opts, msg, http.StatusForbidden)
+					return
+				}
+				submitted := req.Header.Get(opts.HeaderName)
+				if submitted == "" {
+					submitted = req.PostFormValue(opts.FieldName)
+				}
+				if submitted == "" || subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) != 1 {
+					csrfFail(w, req, &opts, "CSRF token invalid", http.StatusForbidden)
+					return
+				}
+			}
+
+			ctx := context.WithValue(req.Context(), csrfContextKey{}, token)
+			next.ServeHTTP(w, req.WithContext(ctx))
+		})
+	}
+}
+
+// gets the current token, creating one if missing
+func csrfCurrentToken(w http.ResponseWriter, req *http.Request, opts *CsrfOptions) (string, error) {
+	if opts.Mode == CsrfSynchronizer {
+		sess := SessionFromContext(req.Context())
+		if sess == nil {
+			return "", errors.New("No session for CSRF token")
+		}
+		if token, ok := sess.Get(csrfSessionKey).(string); ok && token != "" {
+			return token, nil
+		}
+		token, err := newCsrfToken()
+		if err == nil {
+			sess.Set(csrfSessionKey, token)
+		}
+		return token, err
+	}
+
+	if c, err := req.Cookie(opts.CookieName); err == nil && csrfVerify(c.Value, opts.Key) {
+		return c.Value, nil
+	}
+	token, err := newCsrfToken()
+	if err != nil {
+		return "", err
+	}
+	token = csrfSign(token, opts.Key)
+	cookie := opts.Cookie
+	cookie.Name, cookie.Value = opts.CookieName, token
+	http.SetCookie(w, &cookie)
+	return token, nil
+}
+
+func newCsrfToken() (string, error) {
+	b, err := CrandBytes(32)
+	if err != nil {
+		return "", err
+	}
+	return cookieEncoding.EncodeToString(b), nil
+}
+
+// double-submit tokens are random.mac
+func csrfSign(token string, key []byte) string {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(token))
+	return token + "." + cookieEncoding.EncodeToString(h.Sum(nil))
+}
+
+func csrfVerify(signed string, key []byte) bool {
+	token, _ := CutHalf(signed, '.')
+	return token != "" && hmac.Equal([]byte(signed), []byte(csrfSign(token, key)))
+}
+
+// returns a failure message, or empty if ok
+func csrfCheckOrigin(req *http.Request, opts *CsrfOptions) string {
+	source := req.Header.Get("Origin")
+	if source == "null" { // privacy-sensitive or sandboxed contexts
+		return "CSRF origin not allowed"
+	}
+	if source == "" {
+		source = req.Header.Get("Referer")
+	}
+	if source == "" {
+		if req.TLS != nil { // referer is reliable over https
+			return "CSRF referer missing"
+		}
+		return ""
+	}
+
+	u, err := neturl.Parse(source)
+	if err != nil || u.Host == "" {
+		return "CSRF origin invalid"
+	}
+	if strings.EqualFold(u.Host, req.Host) {
+		return ""
+	}
+	for _, host := range opts.TrustedOrigins {
+		if strings.EqualFold(u.Host, host) {
+			return ""
+		}
+	}
+	return "CSRF origin not allowed"
+}
+
+func csrfFail(w http.ResponseWriter, req *http.Request, opts *CsrfOptions, msg string, statusCode int) {
+	switch {
+	case opts.ErrorHandler != nil:
+		opts.ErrorHandler.ServeHTTP(w, req)
+	case opts.PlainError:
+		http.Error(w, msg, statusCode)
+	default:
+		WriteJsonError(w, statusCode, msg)
+	}
+}
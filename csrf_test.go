@@ -0,0 +1,120 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCsrfDoubleSubmit(t *testing.T) {
+	key := []byte("csrf-key")
+	handler := CsrfMiddleware(CsrfOptions{Key: key, TrustedOrigins: []string{"app.example.com"}})(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(CsrfToken(req)))
+		}))
+
+	serve := func(method, cookie, token string, header StrMap) *responseWriter {
+		var req *http.Request
+		if method == "FORM" {
+			form := url.Values{"csrf_token": {token}}
+			req, _ = http.NewRequest("POST", "http://example.com/", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req, _ = http.NewRequest(method, "http://example.com/", nil)
+			if token != "" {
+				req.Header.Set("X-CSRF-Token", token)
+			}
+		}
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "csrf_token", Value: cookie})
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := NewResponseWriter()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// safe methods pass and issue a signed token
+	w := serve("GET", "", "", nil)
+	token := w.String()
+	if w.StatusCode() != 200 || !strings.Contains(w.Header().Get("Set-Cookie"), "csrf_token="+token) {
+		t.Fatalf("expected a new token cookie, got %d %v", w.StatusCode(), w.Header())
+	}
+	for _, method := range []string{"HEAD", "OPTIONS", "TRACE"} {
+		if w := serve(method, "", "", StrMap{"Origin": "https://evil.com"}); w.StatusCode() != 200 {
+			t.Fatalf("%s should be allowed, got %d", method, w.StatusCode())
+		}
+	}
+	if w := serve("GET", token, "", nil); w.String() != token || w.Header().Get("Set-Cookie") != "" {
+		t.Fatal("a valid token cookie should be kept")
+	}
+
+	forged := "attacker.token"
+	var tests = []struct {
+		name          string
+		method        string
+		cookie, token string
+		header        StrMap
+		status        int
+	}{
+		{"header token", "POST", token, token, nil, 200},
+		{"form token", "FORM", token, token, nil, 200},
+		{"same origin", "POST", token, token, StrMap{"Origin": "http://example.com"}, 200},
+		{"trusted origin", "POST", token, token, StrMap{"Origin": "https://app.example.com"}, 200},
+		{"same referer", "POST", token, token, StrMap{"Referer": "http://example.com/form"}, 200},
+		{"missing token", "POST", token, "", nil, 403},
+		{"missing cookie", "POST", "", token, nil, 403},
+		{"mismatched token", "POST", token, token + "x", nil, 403},
+		{"foreign origin", "POST", token, token, StrMap{"Origin": "https://evil.com"}, 403},
+		{"foreign referer", "POST", token, token, StrMap{"Referer": "https://evil.com/x"}, 403},
+		{"null origin", "POST", token, token, StrMap{"Origin": "null", "Referer": "http://example.com/"}, 403},
+		{"unsigned cookie", "POST", forged, forged, nil, 403},
+		{"other key", "DELETE", csrfSign("abc", []byte("other")), csrfSign("abc", []byte("other")), nil, 403},
+	}
+	for _, tt := range tests {
+		if w := serve(tt.method, tt.cookie, tt.token, tt.header); w.StatusCode() != tt.status {
+			t.Fatalf("%s: expected %d, got %d %s", tt.name, tt.status, w.StatusCode(), w.String())
+		}
+	}
+}
+
+func TestCsrfSynchronizer(t *testing.T) {
+	codec, _ := NewCookieCodec([]byte("hash-key"), nil)
+	sessions := NewSessionManager(NewMemorySessionStore(), codec)
+	handler := sessions.Middleware(CsrfMiddleware(CsrfOptions{Mode: CsrfSynchronizer, PlainError: true})(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(CsrfToken(req)))
+		})))
+
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	w := NewResponseWriter()
+	handler.ServeHTTP(w, req)
+	token, cookie := w.String(), w.Header().Get("Set-Cookie")
+	if token == "" || cookie == "" {
+		t.Fatal("expected a token in a new session")
+	}
+	sessionCookie, _ := CutHalf(cookie, ';')
+
+	for _, submitted := range []string{token, "wrong"} {
+		req, _ = http.NewRequest("POST", "http://example.com/", nil)
+		req.Header.Set("Cookie", sessionCookie)
+		req.Header.Set("X-CSRF-Token", submitted)
+		w = NewResponseWriter()
+		handler.ServeHTTP(w, req)
+		if ok := w.StatusCode() == 200; ok != (submitted == token) {
+			t.Fatalf("%s: bad status %d %s", submitted, w.StatusCode(), w.String())
+		}
+	}
+
+	// no session
+	w = NewResponseWriter()
+	CsrfMiddleware(CsrfOptions{Mode: CsrfSynchronizer})(http.NotFoundHandler()).ServeHTTP(w, req)
+	if w.StatusCode() != 500 {
+		t.Fatalf("expected 500 without session, got %d", w.StatusCode())
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// MessageCatalog keeps localized message templates keyed by language and
+// ErrorItem Reason; templates are text/template with the item params plus
+// "message", "domain" and "reason", e.g. "{{.field}} est obligatoire"
+type MessageCatalog struct {
+	DefaultLang string
+
+	mu       sync.RWMutex
+	messages map[string]map[string]*template.Template
+}
+
+// ErrorMessages, if set, localizes errors written by WriteError
+var ErrorMessages *MessageCatalog
+
+func NewMessageCatalog(defaultLang string) *MessageCatalog {
+	return &MessageCatalog{
+		DefaultLang: normalizeLang(defaultLang),
+		messages:    map[string]map[string]*template.Template{},
+	}
+}
+
+func normalizeLang(lang string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(lang), "_", "-"))
+}
+
+func (c *MessageCatalog) Add(lang, reason, msg string) error {
+	tmpl, err := template.New(reason).Parse(msg)
+	if err != nil {
+		return err
+	}
+	lang = normalizeLang(lang)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.messages[lang] == nil {
+		c.messages[lang] = map[string]*template.Template{}
+	}
+	c.messages[lang][reason] = tmpl
+	return nil
+}
+
+func (c *MessageCatalog) AddMap(lang string, msgs StrMap) error {
+	for reason, msg := range msgs {
+		if err := c.Add(lang, reason, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadJson loads {"lang": {"reason": "message", ...}, ...}
+func (c *MessageCatalog) LoadJson(data []byte) error {
+	var all map[string]StrMap
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	for lang, msgs := range all {
+		if err := c.AddMap(lang, msgs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *MessageCatalog) LoadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return c.LoadJson(data)
+}
+
+// LoadDir loads files like "fr.json" or "pt-BR.json", each with
+// {"reason": "message", ...} for the language named by the file
+func (c *MessageCatalog) LoadDir(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var msgs StrMap
+		if err := json.Unmarshal(data, &msgs); err != nil {
+			return err
+		}
+		lang := strings.TrimSuffix(filepath.Base(path), ".json")
+		if err := c.AddMap(lang, msgs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *MessageCatalog) Languages() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ret := make([]string, 0, len(c.messages))
+	for lang := range c.messages {
+		ret = append(ret, lang)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Match negotiates an Accept-Language header value against the catalog
+// languages, trying "fr" for "fr-CA" and the reverse, the first regional
+// variant in sorted order if several, or DefaultLang
+func (c *MessageCatalog) Match(acceptLanguage string) string {
+	type langQ struct {
+		lang string
+		q    float64
+	}
+	var prefs []langQ
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		lang, params := CutHalf(part, ';')
+		lang = normalizeLang(lang)
+		if lang == "" || lang == "*" {
+			continue
+		}
+		q := 1.0
+		if k, v := CutHalf(strings.TrimSpace(params), '='); k == "q" {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			prefs = append(prefs, langQ{lang, q})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+
+	langs := c.Languages()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, p := range prefs {
+		if c.messages[p.lang] != nil {
+			return p.lang
+		}
+		base, _ := CutHalf(p.lang, '-')
+		if c.messages[base] != nil {
+			return base
+		}
+		for _, lang := range langs {
+			if strings.HasPrefix(lang, base+"-") {
+				return lang
+			}
+		}
+	}
+	return c.DefaultLang
+}
+
+// Message renders the message for reason in lang, falling back to the base
+// language and then DefaultLang
+func (c *MessageCatalog) Message(lang, reason string, params Map) (string, bool) {
+	lang = normalizeLang(lang)
+	base, _ := CutHalf(lang, '-')
+
+	c.mu.RLock()
+	var tmpl *template.Template
+	for _, l := range []string{lang, base, c.DefaultLang} {
+		if tmpl = c.messages[l][reason]; tmpl != nil {
+			break
+		}
+	}
+	c.mu.RUnlock()
+	if tmpl == nil {
+		return "", false
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, params); err != nil {
+		return "", false
+	}
+	return buf.String(), true
+}
+
+// WithItemParams returns a copy of err with template params for its items,
+// in order; they are kept out of ErrorItem so that it stays comparable
+func (err Error) WithItemParams(params ...Map) Error {
+	err.params = append([]Map(nil), params...)
+	return err
+}
+
+// Localize translates the items of err by their reasons, and the message of
+// err by the reason of its first item; untranslated messages are kept
+func (c *MessageCatalog) Localize(err Error, lang string) Error {
+	if len(err.Errors) == 0 {
+		return err
+	}
+	items := make([]ErrorItem, len(err.Errors))
+	for i, item := range err.Errors {
+		items[i] = item
+		if item.Reason == "" {
+			continue
+		}
+		params := Map{"message": item.Message, "domain": item.Domain, "reason": item.Reason}
+		if i < len(err.params) {
+			for k, v := range err.params[i] {
+				params[k] = v
+			}
+		}
+		if msg, ok := c.Message(lang, item.Reason, params); ok {
+			items[i].Message = msg
+			if i == 0 {
+				err.Message = msg
+			}
+		}
+	}
+	err.Errors = items
+	return err
+}
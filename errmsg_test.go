@@ -0,0 +1,71 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestMessageCatalog(t *testing.T) {
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "fr.json"), []byte(`{"required": "{{.field}} est obligatoire"}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "pt-BR.json"), []byte(`{"required": "{{.field}} é obrigatório"}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "pt-PT.json"), []byte(`{"required": "{{.field}} é obrigatório"}`), 0644)
+
+	c := NewMessageCatalog("en")
+	if err := c.LoadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.LoadJson([]byte(`{"en": {"required": "{{.field}} is required", "quota": "Too many"}}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i++ { // regional fallback is deterministic
+		if lang := c.Match("pt"); lang != "pt-br" {
+			t.Fatalf("expected pt-br, got %s", lang)
+		}
+	}
+	for accept, want := range map[string]string{
+		"":                       "en",
+		"de":                     "en",
+		"fr-CA, en;q=0.8":        "fr",
+		"en;q=0.5, pt;q=0.9":     "pt-br",
+		"fr;q=0, de, pt-BR;q=.1": "pt-br",
+	} {
+		if lang := c.Match(accept); lang != want {
+			t.Fatalf("%q: expected %s, got %s", accept, want, lang)
+		}
+	}
+
+	e := StdError(BadRequest, "name is required",
+		ErrorItem{Message: "name is required", Reason: "required"},
+		ErrorItem{Message: "unknown", Reason: "other"},
+	).WithItemParams(Map{"field": "nom"})
+	if e.Errors[0] == e.Errors[1] || e.Errors[1] != (ErrorItem{Message: "unknown", Reason: "other"}) {
+		t.Fatal("items should compare by value")
+	}
+	l := c.Localize(e, "fr")
+	if l.Message != "nom est obligatoire" || l.Errors[0].Message != l.Message || l.Errors[1].Message != "unknown" {
+		t.Fatalf("bad localization %+v", l)
+	}
+	if e.Errors[0].Message != "name is required" {
+		t.Fatal("Localize should not modify its argument")
+	}
+
+	ErrorMessages = c
+	defer func() { ErrorMessages = nil }()
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Language", "pt-BR")
+	w := NewResponseWriter()
+	WriteError(w, req, Wrap(e, 0, "saving"))
+	var msg JsonMsg
+	if err := w.Unmarshal(&msg, true); err != nil || msg.Error.Message != "nom é obrigatório" {
+		t.Fatalf("bad response %s %v", w.String(), err)
+	}
+	if w.Header().Get("Content-Language") != "pt-br" {
+		t.Fatalf("bad Content-Language %q", w.Header().Get("Content-Language"))
+	}
+}
@@ -5,21 +5,48 @@ package goutil
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
 )
 
 const (
-	BadRequest          = 400
-	Unauthorized        = 401
-	Forbidden           = 403
-	NotFound            = 404
-	InternalServerError = 500
+	BadRequest           = 400
+	Unauthorized         = 401
+	PaymentRequired      = 402
+	Forbidden            = 403
+	NotFound             = 404
+	MethodNotAllowed     = 405
+	NotAcceptable        = 406
+	RequestTimeout       = 408
+	Conflict             = 409
+	Gone                 = 410
+	PreconditionFailed   = 412
+	PayloadTooLarge      = 413
+	UnsupportedMediaType = 415
+	UnprocessableEntity  = 422
+	TooManyRequests      = 429
+	ClientClosedRequest  = 499 // nginx, for canceled requests
+	InternalServerError  = 500
+	NotImplemented       = 501
+	BadGateway           = 502
+	ServiceUnavailable   = 503
+	GatewayTimeout       = 504
 )
 
 type Error struct {
-	Code    int         `json:"code,omitempty"`
-	Message string      `json:"message,omitempty"`
-	Errors  []ErrorItem `json:"errors,omitempty"`
+	Code    int          `json:"code,omitempty"`
+	Message string       `json:"message,omitempty"`
+	Errors  []ErrorItem  `json:"errors,omitempty"`
+	Details ErrorDetails `json:"details,omitempty"`
+
+	cause  error
+	stack  []uintptr
+	params []Map // per item, see WithItemParams
 }
 
 type ErrorItem struct {
@@ -29,50 +56,296 @@ type ErrorItem struct {
 }
 
 func (err Error) Error() string {
+	if err.cause != nil {
+		if err.Message == "" {
+			return err.cause.Error()
+		}
+		return err.Message + ": " + err.cause.Error()
+	}
 	return err.Message
 }
 
+func (err Error) Unwrap() error {
+	return err.cause
+}
+
+// Is matches by code, e.g. errors.Is(err, Error{Code: NotFound})
+func (err Error) Is(target error) bool {
+	t, ok := target.(Error)
+	return ok && t.Code != 0 && t.Code == err.Code
+}
+
 func StdError(code int, msg string, items ...ErrorItem) Error {
-	return Error{code, msg, items}
+	return Error{Code: code, Message: msg, Errors: items}
 }
-func NotFoundError(msgs ...string) Error {
-	msg := "Not Found"
+
+// CodeError defaults the message to the status text of code
+func CodeError(code int, msgs ...string) Error {
+	msg := http.StatusText(code)
 	if len(msgs) > 0 {
 		msg = msgs[0]
 	}
-	return StdError(NotFound, msg)
+	if msg == "" {
+		msg = "Error " + strconv.Itoa(code)
+	}
+	return StdError(code, msg)
+}
+
+func BadRequestError(msgs ...string) Error {
+	return CodeError(BadRequest, msgs...)
+}
+func UnauthorizedError(msgs ...string) Error {
+	return CodeError(Unauthorized, msgs...)
+}
+func ForbiddenError(msgs ...string) Error {
+	return CodeError(Forbidden, msgs...)
+}
+func NotFoundError(msgs ...string) Error {
+	return CodeError(NotFound, msgs...)
+}
+func MethodNotAllowedError(msgs ...string) Error {
+	return CodeError(MethodNotAllowed, msgs...)
 }
+func NotAcceptableError(msgs ...string) Error {
+	return CodeError(NotAcceptable, msgs...)
+}
+func RequestTimeoutError(msgs ...string) Error {
+	return CodeError(RequestTimeout, msgs...)
+}
+func ConflictError(msgs ...string) Error {
+	return CodeError(Conflict, msgs...)
+}
+func GoneError(msgs ...string) Error {
+	return CodeError(Gone, msgs...)
+}
+func PreconditionFailedError(msgs ...string) Error {
+	return CodeError(PreconditionFailed, msgs...)
+}
+func PayloadTooLargeError(msgs ...string) Error {
+	return CodeError(PayloadTooLarge, msgs...)
+}
+func UnsupportedMediaTypeError(msgs ...string) Error {
+	return CodeError(UnsupportedMediaType, msgs...)
+}
+func UnprocessableEntityError(msgs ...string) Error {
+	return CodeError(UnprocessableEntity, msgs...)
+}
+func TooManyRequestsError(msgs ...string) Error {
+	return CodeError(TooManyRequests, msgs...)
+}
+func InternalError(msgs ...string) Error {
+	return CodeError(InternalServerError, msgs...)
+}
+func NotImplementedError(msgs ...string) Error {
+	return CodeError(NotImplemented, msgs...)
+}
+func BadGatewayError(msgs ...string) Error {
+	return CodeError(BadGateway, msgs...)
+}
+func ServiceUnavailableError(msgs ...string) Error {
+	return CodeError(ServiceUnavailable, msgs...)
+}
+func GatewayTimeoutError(msgs ...string) Error {
+	return CodeError(GatewayTimeout, msgs...)
+}
+
+// CaptureErrorStack makes Wrap and Wrapf record stack traces
+var CaptureErrorStack = false
+
+// Wrap returns nil if err is nil; code 0 takes the code of err, if any,
+// or InternalServerError
+func Wrap(err error, code int, msg string) error {
+	if err == nil {
+		return nil
+	}
+	return wrapError(err, code, msg)
+}
+
+func Wrapf(err error, code int, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+	return wrapError(err, code, fmt.Sprintf(format, args...))
+}
+
+// wrapError must be called directly by Wrap or Wrapf for the stack to start at their caller
+func wrapError(err error, code int, msg string) Error {
+	if code == 0 {
+		code = ErrorCode(err, InternalServerError)
+	}
+	e := Error{Code: code, Message: msg, cause: err}
+	if CaptureErrorStack {
+		e.stack = callers(1)
+	}
+	return e
+}
+
+// WithStack records the current stack trace regardless of CaptureErrorStack
+func (err Error) WithStack() Error {
+	err.stack = callers(0)
+	return err
+}
+
+// StackTrace returns the recorded stack trace, if any, one "func file:line" per frame
+func (err Error) StackTrace() []string {
+	if len(err.stack) == 0 {
+		return nil
+	}
+	var ret []string
+	frames := runtime.CallersFrames(err.stack)
+	for {
+		f, more := frames.Next()
+		ret = append(ret, fmt.Sprintf("%s %s:%d", f.Function, f.File, f.Line))
+		if !more {
+			break
+		}
+	}
+	return ret
+}
+
+// callers records the stack from the caller of its caller, skipping skip more frames
+func callers(skip int) []uintptr {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3+skip, pcs)
+	return pcs[:n]
+}
+
+// Format prints the code, items, stack trace and causes with %+v.
+// Being promoted, it also formats structs embedding Error, which then print
+// as the Error alone unless they implement fmt.Formatter too, as JsonMsg does
+func (err Error) Format(s fmt.State, verb rune) {
+	switch verb {
+	case 'v':
+		if s.Flag('+') {
+			fmt.Fprintf(s, "%d %s", err.Code, err.Message)
+			for _, item := range err.Errors {
+				fmt.Fprintf(s, "\n  - %s", item.Message)
+				if item.Domain != "" || item.Reason != "" {
+					fmt.Fprintf(s, " (%s/%s)", item.Domain, item.Reason)
+				}
+			}
+			for _, frame := range err.StackTrace() {
+				fn, loc := CutHalf(frame, ' ')
+				fmt.Fprintf(s, "\n\t%s\n\t\t%s", fn, loc)
+			}
+			if err.cause != nil {
+				cause := fmt.Sprintf("%+v", err.cause)
+				fmt.Fprintf(s, "\ncaused by: %s", strings.ReplaceAll(cause, "\n", "\n  "))
+			}
+			return
+		}
+		io.WriteString(s, err.Error())
+	case 's':
+		io.WriteString(s, err.Error())
+	case 'q':
+		fmt.Fprintf(s, "%q", err.Error())
+	default:
+		fmt.Fprintf(s, "%%!%c(goutil.Error=%s)", verb, err.Error())
+	}
+}
+
+// IsError checks err and the errors it wraps for code
 func IsError(err error, code int) bool {
-	e, ok := err.(Error)
-	return ok && e.Code == code
+	return errors.Is(err, Error{Code: code})
 }
+
+// ErrorCode returns the code of the first Error in the chain of err
 func ErrorCode(err error, otherwise int) int {
-	if e, ok := err.(Error); ok {
+	var e Error
+	if errors.As(err, &e) {
 		return e.Code
 	}
 	return otherwise
 }
 
-func IsNotFound(err error) bool {
-	return IsError(err, NotFound)
+func IsBadRequest(err error) bool {
+	return IsError(err, BadRequest)
 }
 func IsUnauthorized(err error) bool {
+	return IsError(err, Unauthorized)
+}
+func IsForbidden(err error) bool {
+	return IsError(err, Forbidden)
+}
+func IsNotFound(err error) bool {
 	return IsError(err, NotFound)
 }
+func IsMethodNotAllowed(err error) bool {
+	return IsError(err, MethodNotAllowed)
+}
+func IsNotAcceptable(err error) bool {
+	return IsError(err, NotAcceptable)
+}
+func IsConflict(err error) bool {
+	return IsError(err, Conflict)
+}
+func IsGone(err error) bool {
+	return IsError(err, Gone)
+}
+func IsPreconditionFailed(err error) bool {
+	return IsError(err, PreconditionFailed)
+}
+func IsPayloadTooLarge(err error) bool {
+	return IsError(err, PayloadTooLarge)
+}
+func IsUnsupportedMediaType(err error) bool {
+	return IsError(err, UnsupportedMediaType)
+}
+func IsUnprocessableEntity(err error) bool {
+	return IsError(err, UnprocessableEntity)
+}
+func IsTooManyRequests(err error) bool {
+	return IsError(err, TooManyRequests)
+}
+func IsInternalServerError(err error) bool {
+	return IsError(err, InternalServerError)
+}
+func IsNotImplemented(err error) bool {
+	return IsError(err, NotImplemented)
+}
+func IsBadGateway(err error) bool {
+	return IsError(err, BadGateway)
+}
+func IsServiceUnavailable(err error) bool {
+	return IsError(err, ServiceUnavailable)
+}
+
+// IsTimeout checks for RequestTimeout or GatewayTimeout
+func IsTimeout(err error) bool {
+	return IsError(err, RequestTimeout) || IsError(err, GatewayTimeout)
+}
+
+// IsClientError checks if the code of err, if any, is 4xx
+func IsClientError(err error) bool {
+	code := ErrorCode(err, 0)
+	return code >= 400 && code < 500
+}
+
+// IsServerError checks if the code of err, if any, is 5xx
+func IsServerError(err error) bool {
+	code := ErrorCode(err, 0)
+	return code >= 500 && code < 600
+}
 
 // BufferError is not really of an error type but to "tunnel" return data
-// from functions that only return errors
+// from functions that only return errors; Code and ContentType let it be
+// written as an http response with WriteBufferError
 type BufferError interface {
 	error
 	io.ReadWriter
 	Len() int
 	Bytes() []byte
 	String() string
+	Code() int           // 0 if unspecified
+	ContentType() string // "" if unspecified
 }
 
 type BytesBufferError struct {
 	bytes.Buffer
-	msg string
+	msg         string
+	code        int
+	contentType string
+	cause       error
 }
 
 func NewBufferError(msg string, data []byte) *BytesBufferError {
@@ -84,17 +357,160 @@ func NewBufferError(msg string, data []byte) *BytesBufferError {
 
 func NewStringError(msg, data string) *BytesBufferError {
 	return &BytesBufferError{
-		Buffer: *bytes.NewBufferString(data),
-		msg:    msg,
+		Buffer:      *bytes.NewBufferString(data),
+		msg:         msg,
+		contentType: "text/plain; charset=utf-8",
 	}
 }
 
+// NewJsonError keeps marshal failures as the cause, with code
+// InternalServerError and no data
 func NewJsonError(msg string, data interface{}) *BytesBufferError {
 	buf, err := json.Marshal(data)
 	if err != nil {
-		return NewBufferError(err.Error(), nil)
+		e := NewBufferError(msg, nil).WithCode(InternalServerError)
+		e.cause = err
+		return e
 	}
-	return NewBufferError(msg, buf)
+	return NewBufferError(msg, buf).WithContentType(ctAppJson)
+}
+
+func (e *BytesBufferError) WithCode(code int) *BytesBufferError {
+	e.code = code
+	return e
+}
+
+func (e *BytesBufferError) WithContentType(contentType string) *BytesBufferError {
+	e.contentType = contentType
+	return e
+}
+
+func (e BytesBufferError) Error() string {
+	if e.cause != nil {
+		return e.msg + ": " + e.cause.Error()
+	}
+	return e.msg
+}
+
+func (e BytesBufferError) Code() int           { return e.code }
+func (e BytesBufferError) ContentType() string { return e.contentType }
+func (e BytesBufferError) Unwrap() error       { return e.cause }
+
+// ReaderBufferError produces its data lazily from a reader, which is only
+// read, and closed if an io.Closer, when the data are consumed
+type ReaderBufferError struct {
+	buf         bytes.Buffer
+	r           io.Reader
+	err         error
+	msg         string
+	code        int
+	contentType string
+}
+
+func NewReaderError(msg string, r io.Reader) *ReaderBufferError {
+	return &ReaderBufferError{r: r, msg: msg}
+}
+
+func (e *ReaderBufferError) WithCode(code int) *ReaderBufferError {
+	e.code = code
+	return e
+}
+
+func (e *ReaderBufferError) WithContentType(contentType string) *ReaderBufferError {
+	e.contentType = contentType
+	return e
 }
 
-func (e BytesBufferError) Error() string { return e.msg }
+func (e *ReaderBufferError) Error() string       { return e.msg }
+func (e *ReaderBufferError) Code() int           { return e.code }
+func (e *ReaderBufferError) ContentType() string { return e.contentType }
+
+// Err returns the error, other than io.EOF, from reading the payload
+func (e *ReaderBufferError) Err() error { return e.err }
+
+func (e *ReaderBufferError) Read(p []byte) (int, error) {
+	if e.buf.Len() > 0 || e.r == nil {
+		return e.buf.Read(p)
+	}
+	n, err := e.r.Read(p)
+	if err != nil {
+		e.done(err)
+		if err == io.EOF && n > 0 {
+			err = nil
+		}
+	}
+	return n, err
+}
+
+func (e *ReaderBufferError) WriteTo(w io.Writer) (int64, error) {
+	n, err := e.buf.WriteTo(w)
+	if err != nil || e.r == nil {
+		return n, err
+	}
+	m, err := io.Copy(w, e.r)
+	e.done(err)
+	return n + m, err
+}
+
+// Write appends to the payload, reading it all first
+func (e *ReaderBufferError) Write(p []byte) (int, error) {
+	e.fill()
+	return e.buf.Write(p)
+}
+
+func (e *ReaderBufferError) Len() int {
+	e.fill()
+	return e.buf.Len()
+}
+
+func (e *ReaderBufferError) Bytes() []byte {
+	e.fill()
+	return e.buf.Bytes()
+}
+
+func (e *ReaderBufferError) String() string {
+	e.fill()
+	return e.buf.String()
+}
+
+// Close closes the reader without reading the rest
+func (e *ReaderBufferError) Close() error {
+	if e.r == nil {
+		return nil
+	}
+	var err error
+	if c, ok := e.r.(io.Closer); ok {
+		err = c.Close()
+	}
+	e.r = nil
+	return err
+}
+
+func (e *ReaderBufferError) fill() {
+	if e.r != nil {
+		_, err := e.buf.ReadFrom(e.r)
+		e.done(err)
+	}
+}
+
+func (e *ReaderBufferError) done(err error) {
+	if err != nil && err != io.EOF && e.err == nil {
+		e.err = err
+	}
+	e.Close()
+}
+
+// WriteBufferError writes the payload of e as the response, with status
+// defaulting to 200 and Content-Type to sniffing
+func WriteBufferError(w http.ResponseWriter, e BufferError) error {
+	if ct := e.ContentType(); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	code := e.Code()
+	if code == 0 {
+		code = http.StatusOK
+	}
+	w.WriteHeader(code)
+	_, err := io.Copy(w, e)
+	return err
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestErrorWrap(t *testing.T) {
+	if Wrap(nil, BadRequest, "x") != nil || Wrapf(nil, BadRequest, "x %d", 1) != nil {
+		t.Fatal("wrapping nil should be nil")
+	}
+
+	base := NotFoundError("no user")
+	err := Wrapf(base, 0, "loading %s", "user")
+	err = fmt.Errorf("handler: %w", err)
+	err = Wrap(err, InternalServerError, "request failed")
+
+	if err.Error() != "request failed: handler: loading user: no user" {
+		t.Fatalf("bad message %q", err.Error())
+	}
+	if !IsNotFound(err) || !IsError(err, InternalServerError) || IsError(err, BadRequest) {
+		t.Fatal("IsError should match codes through the chain")
+	}
+	if !errors.Is(err, Error{Code: NotFound}) || errors.Is(err, Error{}) {
+		t.Fatal("errors.Is should match by code")
+	}
+	if ErrorCode(err, 0) != InternalServerError {
+		t.Fatalf("bad code %d", ErrorCode(err, 0))
+	}
+
+	// code 0 takes the wrapped code
+	var e Error
+	if !errors.As(errors.Unwrap(errors.Unwrap(err)), &e) || e.Code != NotFound {
+		t.Fatalf("bad inherited code %d", e.Code)
+	}
+
+	wrapped := Wrap(io.EOF, BadRequest, "reading body")
+	if !errors.Is(wrapped, io.EOF) || !IsError(wrapped, BadRequest) {
+		t.Fatal("should wrap plain errors")
+	}
+	if ErrorCode(Wrap(io.EOF, 0, "x"), 0) != InternalServerError {
+		t.Fatal("plain errors default to InternalServerError")
+	}
+}
+
+func TestErrorStack(t *testing.T) {
+	err := StdError(BadRequest, "bad input", ErrorItem{Message: "missing name", Reason: "required"})
+	if s := fmt.Sprintf("%v", err); s != "bad input" {
+		t.Fatalf("bad %%v %q", s)
+	}
+	if len(err.StackTrace()) != 0 {
+		t.Fatal("no stack expected")
+	}
+
+	err = err.WithStack()
+	if len(err.StackTrace()) == 0 || !strings.Contains(err.StackTrace()[0], "TestErrorStack") {
+		t.Fatalf("bad stack %v", err.StackTrace())
+	}
+
+	CaptureErrorStack = true
+	defer func() { CaptureErrorStack = false }()
+	for _, wrapped := range []error{Wrap(err, 0, "outer"), Wrapf(err, 0, "%s", "outer")} {
+		if trace := wrapped.(Error).StackTrace(); len(trace) == 0 || !strings.HasPrefix(trace[0], "github.com/jyrobin/goutil.TestErrorStack ") {
+			t.Fatalf("stack should start at the caller: %v", trace)
+		}
+	}
+	s := fmt.Sprintf("%+v", Wrap(err, 0, "outer"))
+	for _, want := range []string{"400 outer", "missing name (/required)", "TestErrorStack", "caused by: 400 bad input"} {
+		if !strings.Contains(s, want) {
+			t.Fatalf("%q not in %s", want, s)
+		}
+	}
+}
+
+func TestJsonMsgFormat(t *testing.T) {
+	msg := JsonMsg{Data: Data{Kind: "user"}}
+	want := `{"apiVersion":"","data":{"kind":"user"},"error":{}}`
+	for _, format := range []string{"%v", "%s"} {
+		if s := fmt.Sprintf(format, msg); s != want {
+			t.Fatalf("%s: expected %s, got %q", format, want, s)
+		}
+	}
+	if s := fmt.Sprintf("%v", &msg); s != want {
+		t.Fatalf("expected %s, got %q", want, s)
+	}
+	if s := fmt.Sprintf("%q", msg); s != strconv.Quote(want) {
+		t.Fatalf("bad %%q %s", s)
+	}
+	msg.Error = Error{Code: NotFound, Message: "No user"}
+	if s := fmt.Sprintf("%+v", msg); !strings.Contains(s, "\n  \"error\": {\n    \"code\": 404") {
+		t.Fatalf("bad %%+v %s", s)
+	}
+	if s := fmt.Sprintf("%v", msg.Error); s != "No user" {
+		t.Fatalf("bad error %q", s)
+	}
+}
+
+func TestErrorCatalogue(t *testing.T) {
+	if err := UnauthorizedError(); err.Code != Unauthorized || err.Message != "Unauthorized" {
+		t.Fatalf("bad default %v", err)
+	}
+	if IsUnauthorized(NotFoundError()) || !IsUnauthorized(UnauthorizedError("who?")) {
+		t.Fatal("IsUnauthorized should check Unauthorized")
+	}
+	if !IsTimeout(GatewayTimeoutError()) || !IsTimeout(RequestTimeoutError()) || IsTimeout(GoneError()) {
+		t.Fatal("bad IsTimeout")
+	}
+	if !IsClientError(ConflictError()) || IsClientError(InternalError()) || !IsServerError(ServiceUnavailableError()) {
+		t.Fatal("bad client/server classification")
+	}
+	if CodeError(599).Message != "Error 599" {
+		t.Fatal("bad message for unknown code")
+	}
+}
+
+func TestGrpcCode(t *testing.T) {
+	for c := GrpcOK; c <= GrpcUnauthenticated; c++ {
+		if p, ok := ParseGrpcCode(c.String()); !ok || p != c {
+			t.Fatalf("bad name %s", c)
+		}
+	}
+	for _, c := range []GrpcCode{GrpcInvalidArgument, GrpcNotFound, GrpcPermissionDenied, GrpcUnauthenticated,
+		GrpcResourceExhausted, GrpcDeadlineExceeded, GrpcUnimplemented, GrpcUnavailable, GrpcInternal} {
+		if back := GrpcCodeFromHttp(c.HttpCode()); back != c {
+			t.Fatalf("%s round-trips to %s", c, back)
+		}
+	}
+	if ErrorGrpcCode(nil) != GrpcOK || ErrorGrpcCode(io.EOF) != GrpcUnknown || ErrorGrpcCode(Wrap(GoneError(), 0, "x")) != GrpcNotFound {
+		t.Fatal("bad ErrorGrpcCode")
+	}
+	if err := GrpcError(GrpcAlreadyExists); !IsConflict(err) || err.Message != "ALREADY_EXISTS" {
+		t.Fatalf("bad GrpcError %v", err)
+	}
+}
+
+type closeCounter struct {
+	io.Reader
+	closed int
+}
+
+func (c *closeCounter) Close() error {
+	c.closed++
+	return nil
+}
+
+func TestBufferError(t *testing.T) {
+	e := NewJsonError("result", Map{"bad": func() {}})
+	var jsonErr *json.UnsupportedTypeError
+	if e.Code() != InternalServerError || !errors.As(e, &jsonErr) || e.Len() != 0 {
+		t.Fatalf("marshal failure should be kept, got %v", e)
+	}
+
+	w := NewResponseWriter()
+	if err := WriteBufferError(w, NewJsonError("result", Map{"a": 1}).WithCode(http.StatusCreated)); err != nil {
+		t.Fatal(err)
+	}
+	if w.StatusCode() != http.StatusCreated || w.Header().Get("Content-Type") != ctAppJson || w.String() != `{"a":1}` {
+		t.Fatalf("bad response %d %s", w.StatusCode(), w.String())
+	}
+
+	src := &closeCounter{Reader: strings.NewReader("streamed")}
+	lazy := NewReaderError("lazy", src).WithContentType("text/plain")
+	if src.closed != 0 {
+		t.Fatal("should not read eagerly")
+	}
+	var be BufferError = lazy
+	w = NewResponseWriter()
+	if err := WriteBufferError(w, be); err != nil || w.String() != "streamed" || src.closed != 1 {
+		t.Fatalf("bad lazy write %q %v %d", w.String(), err, src.closed)
+	}
+
+	lazy = NewReaderError("lazy", strings.NewReader("abc"))
+	lazy.Write([]byte("def"))
+	if lazy.String() != "abcdef" || lazy.Len() != 6 || lazy.Err() != nil {
+		t.Fatalf("bad buffered payload %q", lazy.String())
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// REF: https://cloud.google.com/apis/design/errors#error_details
+// details serialize with an "@type" member, as in google.rpc.Status
+
+type ErrorDetail interface {
+	DetailType() string
+}
+
+const errorDetailPrefix = "type.googleapis.com/google.rpc."
+
+type FieldViolation struct {
+	Field       string `json:"field"`              // path, e.g. "user.emails[0]"
+	Location    string `json:"location,omitempty"` // e.g. "body", "query", "header", "path"
+	Description string `json:"description,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+type BadRequestDetail struct {
+	FieldViolations []FieldViolation `json:"fieldViolations"`
+}
+
+func (BadRequestDetail) DetailType() string { return errorDetailPrefix + "BadRequest" }
+
+// RetryInfo serializes RetryDelay as seconds like "1.5s"
+type RetryInfo struct {
+	RetryDelay time.Duration `json:"-"`
+}
+
+func (RetryInfo) DetailType() string { return errorDetailPrefix + "RetryInfo" }
+
+func (r RetryInfo) MarshalJSON() ([]byte, error) {
+	secs := strconv.FormatFloat(r.RetryDelay.Seconds(), 'f', -1, 64)
+	return json.Marshal(Map{"retryDelay": secs + "s"})
+}
+
+func (r *RetryInfo) UnmarshalJSON(data []byte) error {
+	var m struct {
+		RetryDelay string `json:"retryDelay"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	d, err := time.ParseDuration(m.RetryDelay)
+	if err != nil {
+		return err
+	}
+	r.RetryDelay = d
+	return nil
+}
+
+type QuotaViolation struct {
+	Subject     string `json:"subject"`
+	Description string `json:"description,omitempty"`
+}
+
+type QuotaFailure struct {
+	Violations []QuotaViolation `json:"violations"`
+}
+
+func (QuotaFailure) DetailType() string { return errorDetailPrefix + "QuotaFailure" }
+
+type HelpLink struct {
+	Description string `json:"description,omitempty"`
+	Url         string `json:"url"`
+}
+
+type Help struct {
+	Links []HelpLink `json:"links"`
+}
+
+func (Help) DetailType() string { return errorDetailPrefix + "Help" }
+
+// DebugInfo is only serialized if ExposeErrorDebugInfo is set
+type DebugInfo struct {
+	StackEntries []string `json:"stackEntries,omitempty"`
+	Detail       string   `json:"detail,omitempty"`
+}
+
+func (DebugInfo) DetailType() string { return errorDetailPrefix + "DebugInfo" }
+
+// ExposeErrorDebugInfo lets DebugInfo details be serialized, e.g. in development
+var ExposeErrorDebugInfo = false
+
+// UnknownDetail keeps details of unregistered types, including "@type"
+type UnknownDetail Map
+
+func (d UnknownDetail) DetailType() string {
+	s, _ := d["@type"].(string)
+	return s
+}
+
+var (
+	errorDetailMu    sync.RWMutex
+	errorDetailTypes = map[string]func() ErrorDetail{
+		BadRequestDetail{}.DetailType(): func() ErrorDetail { return &BadRequestDetail{} },
+		RetryInfo{}.DetailType():        func() ErrorDetail { return &RetryInfo{} },
+		QuotaFailure{}.DetailType():     func() ErrorDetail { return &QuotaFailure{} },
+		Help{}.DetailType():             func() ErrorDetail { return &Help{} },
+		DebugInfo{}.DetailType():        func() ErrorDetail { return &DebugInfo{} },
+	}
+)
+
+// RegisterErrorDetail lets custom details be decoded; factory returns a
+// pointer to be unmarshaled into, whose element is kept in ErrorDetails
+func RegisterErrorDetail(typ string, factory func() ErrorDetail) {
+	errorDetailMu.Lock()
+	defer errorDetailMu.Unlock()
+	errorDetailTypes[typ] = factory
+}
+
+type ErrorDetails []ErrorDetail
+
+// exposed returns the details to serialize, or nil if none
+func (ds ErrorDetails) exposed() ErrorDetails {
+	var ret ErrorDetails
+	for _, d := range ds {
+		if !ExposeErrorDebugInfo {
+			switch d.(type) {
+			case DebugInfo, *DebugInfo:
+				continue
+			}
+		}
+		ret = append(ret, d)
+	}
+	return ret
+}
+
+func (ds ErrorDetails) MarshalJSON() ([]byte, error) {
+	ds = ds.exposed()
+	ret := make([]Map, 0, len(ds))
+	for _, d := range ds {
+		buf, err := json.Marshal(d)
+		if err != nil {
+			return nil, err
+		}
+		m := Map{}
+		if err := json.Unmarshal(buf, &m); err != nil {
+			return nil, fmt.Errorf("Error detail %s is not an object", d.DetailType())
+		}
+		m["@type"] = d.DetailType()
+		ret = append(ret, m)
+	}
+	return json.Marshal(ret)
+}
+
+func (ds *ErrorDetails) UnmarshalJSON(data []byte) error {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return err
+	}
+	ret := make(ErrorDetails, 0, len(raws))
+	for _, raw := range raws {
+		var head struct {
+			Type string `json:"@type"`
+		}
+		if err := json.Unmarshal(raw, &head); err != nil {
+			return err
+		}
+
+		errorDetailMu.RLock()
+		factory := errorDetailTypes[head.Type]
+		errorDetailMu.RUnlock()
+		if factory == nil {
+			var m UnknownDetail
+			if err := json.Unmarshal(raw, &m); err != nil {
+				return err
+			}
+			ret = append(ret, m)
+			continue
+		}
+
+		d := factory()
+		if err := json.Unmarshal(raw, d); err != nil {
+			return err
+		}
+		ret = append(ret, derefDetail(d))
+	}
+	*ds = ret
+	return nil
+}
+
+// keeps the element of factory pointers, unless only the pointer is an ErrorDetail
+func derefDetail(d ErrorDetail) ErrorDetail {
+	if v := reflect.ValueOf(d); v.Kind() == reflect.Ptr && !v.IsNil() {
+		if elem, ok := v.Elem().Interface().(ErrorDetail); ok {
+			return elem
+		}
+	}
+	return d
+}
+
+// WithDetails returns a copy of err with details appended, pointers to
+// details kept as values so that the queries below find them
+func (err Error) WithDetails(details ...ErrorDetail) Error {
+	err.Details = append(ErrorDetails(nil), err.Details...)
+	for _, d := range details {
+		err.Details = append(err.Details, derefDetail(d))
+	}
+	return err
+}
+
+// ValidationError creates an UnprocessableEntity error with field violations
+func ValidationError(msg string, violations ...FieldViolation) Error {
+	return CodeError(UnprocessableEntity, msg).WithDetails(BadRequestDetail{violations})
+}
+
+// detail queries, collecting from all Errors in the chain of err
+
+func ErrorDetailsOf(err error) ErrorDetails {
+	var ret ErrorDetails
+	for ; err != nil; err = errors.Unwrap(err) {
+		if e, ok := err.(Error); ok {
+			for _, d := range e.Details {
+				ret = append(ret, derefDetail(d))
+			}
+		} else if m, ok := err.(*MultiError); ok {
+			for _, member := range m.Errors() {
+				ret = append(ret, ErrorDetailsOf(member)...)
+			}
+			break
+		}
+	}
+	return ret
+}
+
+func FieldViolations(err error) []FieldViolation {
+	var ret []FieldViolation
+	for _, d := range ErrorDetailsOf(err) {
+		if br, ok := d.(BadRequestDetail); ok {
+			ret = append(ret, br.FieldViolations...)
+		}
+	}
+	return ret
+}
+
+// FieldViolationsOf filters violations for field and its sub-paths
+func FieldViolationsOf(err error, field string) []FieldViolation {
+	var ret []FieldViolation
+	for _, v := range FieldViolations(err) {
+		if v.Field == field || strings.HasPrefix(v.Field, field+".") || strings.HasPrefix(v.Field, field+"[") {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+func RetryDelay(err error) (time.Duration, bool) {
+	for _, d := range ErrorDetailsOf(err) {
+		if ri, ok := d.(RetryInfo); ok {
+			return ri.RetryDelay, true
+		}
+	}
+	return 0, false
+}
+
+func QuotaViolations(err error) []QuotaViolation {
+	var ret []QuotaViolation
+	for _, d := range ErrorDetailsOf(err) {
+		if qf, ok := d.(QuotaFailure); ok {
+			ret = append(ret, qf.Violations...)
+		}
+	}
+	return ret
+}
+
+func HelpLinks(err error) []HelpLink {
+	var ret []HelpLink
+	for _, d := range ErrorDetailsOf(err) {
+		if h, ok := d.(Help); ok {
+			ret = append(ret, h.Links...)
+		}
+	}
+	return ret
+}
+
+func ErrorDebugInfo(err error) (DebugInfo, bool) {
+	for _, d := range ErrorDetailsOf(err) {
+		if di, ok := d.(DebugInfo); ok {
+			return di, true
+		}
+	}
+	return DebugInfo{}, false
+}
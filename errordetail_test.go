@@ -0,0 +1,126 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrorDetailsRoundTrip(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		err := ValidationError("invalid user",
+			FieldViolation{Field: "user.emails[0]", Location: "body", Description: "bad email"},
+			FieldViolation{Field: "user.name", Location: "body", Description: "required"},
+		).WithDetails(
+			RetryInfo{1500 * time.Millisecond},
+			QuotaFailure{[]QuotaViolation{{Subject: "project:x", Description: "daily limit"}}},
+			Help{[]HelpLink{{Description: "docs", Url: "https://example.com/docs"}}},
+			DebugInfo{Detail: "secret"},
+			UnknownDetail{"@type": "custom", "n": 1},
+		)
+		WriteError(w, req, Wrap(err, 0, "saving"))
+	}))
+	defer srv.Close()
+
+	for _, accept := range []string{ctAppJson, ctProblemJson} {
+		res, err := HttpDo(http.MethodGet, srv.URL, "", nil, StrMap{"Accept": accept})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Header.Get("Retry-After") != "2" {
+			t.Fatalf("bad Retry-After %q", res.Header.Get("Retry-After"))
+		}
+		err = UnmarshalResponse(res, &Map{})
+		res.Body.Close()
+
+		if !IsUnprocessableEntity(err) || len(FieldViolations(err)) != 2 {
+			t.Fatalf("bad violations %+v for %s", err, accept)
+		}
+		if vs := FieldViolationsOf(err, "user.emails"); len(vs) != 1 || vs[0].Description != "bad email" {
+			t.Fatalf("bad field query %+v", vs)
+		}
+		if d, ok := RetryDelay(err); !ok || d != 1500*time.Millisecond {
+			t.Fatalf("bad retry delay %v", d)
+		}
+		if qs := QuotaViolations(err); len(qs) != 1 || qs[0].Subject != "project:x" {
+			t.Fatalf("bad quota %+v", qs)
+		}
+		if ls := HelpLinks(err); len(ls) != 1 || ls[0].Url != "https://example.com/docs" {
+			t.Fatalf("bad links %+v", ls)
+		}
+		if _, ok := ErrorDebugInfo(err); ok {
+			t.Fatal("debug info should be hidden")
+		}
+		if details := ErrorDetailsOf(err); details[len(details)-1].DetailType() != "custom" {
+			t.Fatalf("unknown detail lost: %+v", details)
+		}
+	}
+
+	ExposeErrorDebugInfo = true
+	defer func() { ExposeErrorDebugInfo = false }()
+	w := NewResponseWriter()
+	WriteError(w, nil, BadRequestError().WithDetails(DebugInfo{StackEntries: []string{"main.go:1"}}))
+	if !strings.Contains(w.String(), "main.go:1") {
+		t.Fatalf("debug info expected in %s", w.String())
+	}
+}
+
+type testDetail struct {
+	Ticket string `json:"ticket"`
+}
+
+func (testDetail) DetailType() string { return "example.com/TestDetail" }
+
+func TestErrorDetailsCustomAndHidden(t *testing.T) {
+	RegisterErrorDetail(testDetail{}.DetailType(), func() ErrorDetail { return &testDetail{} })
+	var e Error
+	if err := json.Unmarshal([]byte(`{"code":500,"details":[{"@type":"example.com/TestDetail","ticket":"T-1"}]}`), &e); err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := e.Details[0].(testDetail); !ok || d.Ticket != "T-1" {
+		t.Fatalf("expected a testDetail value, got %#v", e.Details[0])
+	}
+
+	// all hidden: no "details" in responses, and nothing leaked elsewhere
+	err := InternalError("oops").WithDetails(DebugInfo{Detail: "secret"}, &DebugInfo{Detail: "secret"})
+	buf, _ := json.Marshal(JsonMsg{Error: err})
+	if string(buf) != `{"apiVersion":"","data":{},"error":{"code":500,"message":"oops","details":[]}}` {
+		t.Fatalf("unexpected json %s", buf)
+	}
+	for _, accept := range []string{ctAppJson, ctProblemJson} {
+		w := NewResponseWriter()
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", accept)
+		WriteError(w, req, err)
+		if s := w.String(); strings.Contains(s, "details") || strings.Contains(s, "secret") || !strings.Contains(s, "oops") {
+			t.Fatalf("details should be omitted: %s", s)
+		}
+	}
+
+	// pointers are found by the queries
+	err = TooManyRequestsError().WithDetails(&RetryInfo{2 * time.Second}, &Help{[]HelpLink{{Url: "https://example.com"}}},
+		&BadRequestDetail{[]FieldViolation{{Field: "n"}}}, &QuotaFailure{[]QuotaViolation{{Subject: "x"}}}, &DebugInfo{Detail: "d"})
+	if d, ok := RetryDelay(err); !ok || d != 2*time.Second {
+		t.Fatalf("bad retry delay %v", d)
+	}
+	if len(HelpLinks(err)) != 1 || len(FieldViolations(err)) != 1 || len(QuotaViolations(err)) != 1 {
+		t.Fatalf("pointer details not found %+v", err.Details)
+	}
+	if di, ok := ErrorDebugInfo(Wrap(err, 0, "wrapped")); !ok || di.Detail != "d" {
+		t.Fatal("pointer debug info not found")
+	}
+	// also when set directly
+	if _, ok := RetryDelay(Error{Code: 503, Details: ErrorDetails{&RetryInfo{time.Second}}}); !ok {
+		t.Fatal("pointer retry info not found")
+	}
+	w := NewResponseWriter()
+	WriteError(w, nil, err)
+	if w.Header().Get("Retry-After") != "2" {
+		t.Fatalf("bad Retry-After %q", w.Header().Get("Retry-After"))
+	}
+}
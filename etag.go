@@ -0,0 +1,71 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"time"
+)
+
+// server-side conditional GET; http.ServeContent does the heavy lifting for
+// If-None-Match, If-Modified-Since, If-Match and Range requests
+
+func ETag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	tag := `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+	if weak {
+		return "W/" + tag
+	}
+	return tag
+}
+
+// ServeBytes replies with body, setting the ETag if not yet set and honoring
+// conditional and range requests
+func ServeBytes(w http.ResponseWriter, req *http.Request, body []byte, contentType string, modTime time.Time) {
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if w.Header().Get("ETag") == "" {
+		w.Header().Set("ETag", ETag(body, false))
+	}
+	http.ServeContent(w, req, "", modTime, bytes.NewReader(body))
+}
+
+// ETagMiddleware buffers successful GET/HEAD responses to compute their ETag,
+// replying 304 or partial content as requested. A Last-Modified header set by
+// the handler is used for If-Modified-Since. Range requests are served either
+// way, but a weak ETag never matches If-Range, which then gets the full body.
+func ETagMiddleware(weak bool) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Method != http.MethodGet && req.Method != http.MethodHead {
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			rec := NewResponseWriter()
+			next.ServeHTTP(rec, req)
+
+			for k, v := range rec.Header() {
+				w.Header()[k] = v
+			}
+			if rec.StatusCode() != http.StatusOK {
+				w.WriteHeader(rec.StatusCode())
+				w.Write(rec.Bytes())
+				return
+			}
+
+			var modTime time.Time
+			if lm := rec.Header().Get("Last-Modified"); lm != "" {
+				modTime, _ = http.ParseTime(lm)
+			}
+			if w.Header().Get("ETag") == "" {
+				w.Header().Set("ETag", ETag(rec.Bytes(), weak))
+			}
+			http.ServeContent(w, req, "", modTime, bytes.NewReader(rec.Bytes()))
+		})
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestETagMiddleware(t *testing.T) {
+	body := []byte("0123456789")
+	for _, weak := range []bool{false, true} {
+		handler := ETagMiddleware(weak)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write(body)
+		}))
+		tag := ETag(body, weak)
+
+		var tests = []struct {
+			header, value string
+			status        int
+			body          string
+		}{
+			{"", "", 200, "0123456789"},
+			{"If-None-Match", tag, 304, ""},
+			{"If-None-Match", `"other"`, 200, "0123456789"},
+			{"Range", "bytes=2-4", 206, "234"},
+			{"If-Range", tag, 206, "234"},
+		}
+		for _, tt := range tests {
+			req, _ := http.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			if tt.header == "If-Range" {
+				req.Header.Set("Range", "bytes=2-4")
+				if weak {
+					// weak validators never match If-Range
+					tt.status, tt.body = 200, "0123456789"
+				}
+			}
+			w := NewResponseWriter()
+			handler.ServeHTTP(w, req)
+			if w.StatusCode() != tt.status || w.String() != tt.body {
+				t.Fatalf("weak %v, %s: expected %d %q, got %d %q", weak, tt.header, tt.status, tt.body, w.StatusCode(), w.String())
+			}
+			if w.Header().Get("ETag") != tag {
+				t.Fatalf("weak %v, %s: bad ETag %q", weak, tt.header, w.Header().Get("ETag"))
+			}
+		}
+	}
+
+	notFound := ETagMiddleware(false)(http.NotFoundHandler())
+	w := NewResponseWriter()
+	req, _ := http.NewRequest("GET", "/", nil)
+	notFound.ServeHTTP(w, req)
+	if w.StatusCode() != 404 || w.Header().Get("ETag") != "" {
+		t.Fatal("unsuccessful responses should pass through")
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"strconv"
+	"strings"
+)
+
+// REF: https://github.com/googleapis/googleapis/blob/master/google/rpc/code.proto
+
+// GrpcCode mirrors the canonical gRPC status codes without depending on grpc
+type GrpcCode int
+
+const (
+	GrpcOK GrpcCode = iota
+	GrpcCanceled
+	GrpcUnknown
+	GrpcInvalidArgument
+	GrpcDeadlineExceeded
+	GrpcNotFound
+	GrpcAlreadyExists
+	GrpcPermissionDenied
+	GrpcResourceExhausted
+	GrpcFailedPrecondition
+	GrpcAborted
+	GrpcOutOfRange
+	GrpcUnimplemented
+	GrpcInternal
+	GrpcUnavailable
+	GrpcDataLoss
+	GrpcUnauthenticated
+)
+
+var grpcCodeNames = []string{
+	"OK",
+	"CANCELLED",
+	"UNKNOWN",
+	"INVALID_ARGUMENT",
+	"DEADLINE_EXCEEDED",
+	"NOT_FOUND",
+	"ALREADY_EXISTS",
+	"PERMISSION_DENIED",
+	"RESOURCE_EXHAUSTED",
+	"FAILED_PRECONDITION",
+	"ABORTED",
+	"OUT_OF_RANGE",
+	"UNIMPLEMENTED",
+	"INTERNAL",
+	"UNAVAILABLE",
+	"DATA_LOSS",
+	"UNAUTHENTICATED",
+}
+
+// String returns the canonical name, e.g. "NOT_FOUND"
+func (c GrpcCode) String() string {
+	if c >= 0 && int(c) < len(grpcCodeNames) {
+		return grpcCodeNames[c]
+	}
+	return "CODE(" + strconv.Itoa(int(c)) + ")"
+}
+
+// ParseGrpcCode accepts canonical names, case-insensitively, or numbers
+func ParseGrpcCode(s string) (GrpcCode, bool) {
+	for i, name := range grpcCodeNames {
+		if strings.EqualFold(s, name) {
+			return GrpcCode(i), true
+		}
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 && n < len(grpcCodeNames) {
+		return GrpcCode(n), true
+	}
+	return GrpcUnknown, false
+}
+
+// HttpCode maps to the HTTP status per the google.rpc.Code mapping
+func (c GrpcCode) HttpCode() int {
+	switch c {
+	case GrpcOK:
+		return 200
+	case GrpcCanceled:
+		return ClientClosedRequest
+	case GrpcInvalidArgument, GrpcOutOfRange, GrpcFailedPrecondition:
+		return BadRequest
+	case GrpcDeadlineExceeded:
+		return GatewayTimeout
+	case GrpcNotFound:
+		return NotFound
+	case GrpcAlreadyExists, GrpcAborted:
+		return Conflict
+	case GrpcPermissionDenied:
+		return Forbidden
+	case GrpcUnauthenticated:
+		return Unauthorized
+	case GrpcResourceExhausted:
+		return TooManyRequests
+	case GrpcUnimplemented:
+		return NotImplemented
+	case GrpcUnavailable:
+		return ServiceUnavailable
+	}
+	return InternalServerError // Unknown, Internal, DataLoss
+}
+
+// GrpcCodeFromHttp maps an HTTP status to the closest canonical code
+func GrpcCodeFromHttp(code int) GrpcCode {
+	switch code {
+	case BadRequest, MethodNotAllowed, NotAcceptable, PayloadTooLarge,
+		UnsupportedMediaType, UnprocessableEntity:
+		return GrpcInvalidArgument
+	case Unauthorized:
+		return GrpcUnauthenticated
+	case Forbidden:
+		return GrpcPermissionDenied
+	case NotFound, Gone:
+		return GrpcNotFound
+	case Conflict:
+		return GrpcAlreadyExists
+	case PreconditionFailed:
+		return GrpcFailedPrecondition
+	case TooManyRequests:
+		return GrpcResourceExhausted
+	case ClientClosedRequest:
+		return GrpcCanceled
+	case RequestTimeout, GatewayTimeout:
+		return GrpcDeadlineExceeded
+	case NotImplemented:
+		return GrpcUnimplemented
+	case BadGateway, ServiceUnavailable:
+		return GrpcUnavailable
+	case InternalServerError:
+		return GrpcInternal
+	}
+	switch {
+	case code >= 200 && code < 300:
+		return GrpcOK
+	case code >= 400 && code < 500:
+		return GrpcFailedPrecondition
+	}
+	return GrpcUnknown
+}
+
+// ErrorGrpcCode maps the code of err; nil is GrpcOK and errors without codes
+// GrpcUnknown
+func ErrorGrpcCode(err error) GrpcCode {
+	if err == nil {
+		return GrpcOK
+	}
+	code := ErrorCode(err, 0)
+	if code == 0 {
+		return GrpcUnknown
+	}
+	return GrpcCodeFromHttp(code)
+}
+
+// GrpcError creates an Error with the HTTP status mapped from code, the
+// message defaulting to the canonical name
+func GrpcError(code GrpcCode, msgs ...string) Error {
+	if len(msgs) == 0 {
+		msgs = []string{code.String()}
+	}
+	return CodeError(code.HttpCode(), msgs...)
+}
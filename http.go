@@ -63,7 +63,7 @@ func UnmarshalResponse(res *http.Response, ret interface{}) error {
 	}
 
 	if res.StatusCode >= 400 {
-		return fmt.Errorf("StatusCode %d", res.StatusCode)
+		return ResponseError(res)
 	}
 
 	return json.NewDecoder(res.Body).Decode(ret)
@@ -250,8 +250,12 @@ func FormRequest(method, url string, data, headers StrMap) (*http.Request, error
 
 // http calls
 
+// HttpClient is used by the http call helpers below, e.g. replace it or its
+// Transport to add caching or other client-side behaviors
+var HttpClient = &http.Client{}
+
 func HttpGet(url string) ([]byte, error) {
-	res, err := http.Get(url)
+	res, err := HttpClient.Get(url)
 
 	if err != nil {
 		return nil, err
@@ -266,8 +270,7 @@ func HttpDo(method, url, contentType string, data []byte, headers StrMap) (*http
 		return nil, err
 	}
 
-	client := &http.Client{}
-	return client.Do(req)
+	return HttpClient.Do(req)
 }
 
 func FormDo(method, url string, data, headers StrMap) (*http.Response, error) {
@@ -276,8 +279,7 @@ func FormDo(method, url string, data, headers StrMap) (*http.Response, error) {
 		return nil, err
 	}
 
-	client := &http.Client{}
-	return client.Do(req)
+	return HttpClient.Do(req)
 }
 
 func HttpCall(method, url, contentType string, data []byte, headers StrMap) ([]byte, *http.Response, error) {
@@ -314,7 +316,7 @@ func AjaxGet(url string, headers StrMap) ([]byte, error) {
 }
 
 func AjaxUnmarshal(method, url string, jsonStr []byte, headers StrMap, ret interface{}) error {
-	res, err := HttpDo(method, url, ctAppJson, nil, headers)
+	res, err := HttpDo(method, url, ctAppJson, jsonStr, headers)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,31 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAjaxUnmarshalSendsBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ReadRequestBody(req)
+		w.Header().Set("Content-Type", req.Header.Get("Content-Type"))
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	var ret Map
+	if err := AjaxUnmarshal(http.MethodPost, srv.URL, []byte(`{"name":"x"}`), nil, &ret); err != nil {
+		t.Fatal(err)
+	}
+	if ret["name"] != "x" {
+		t.Fatalf("the request body was not sent, got %v", ret)
+	}
+
+	var data JsonMsg
+	if err := AjaxPostUnmarshal(srv.URL, []byte(`{"apiVersion":"1"}`), nil, &data); err != nil || data.ApiVersion != "1" {
+		t.Fatalf("bad post %+v %v", data, err)
+	}
+}
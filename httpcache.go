@@ -0,0 +1,322 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// client-side caching of GET responses, honoring Cache-Control max-age,
+// no-cache and no-store, and revalidating with ETag/Last-Modified.
+// Vary is not considered; requests with Authorization are keyed separately.
+// Range requests bypass the cache, and conditional requests of the caller
+// are not answered from it, getting the 304 of the origin as is.
+
+const HttpCacheHeader = "X-From-Cache"
+
+type CachedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	StoredAt   time.Time   `json:"storedAt"`
+}
+
+type HttpCacheStore interface {
+	Get(key string) (*CachedResponse, bool)
+	Set(key string, res *CachedResponse)
+	Delete(key string)
+}
+
+// CacheTransport passes requests through uncached if Store is nil
+type CacheTransport struct {
+	Transport http.RoundTripper
+	Store     HttpCacheStore
+	Now       func() time.Time
+}
+
+// NewCacheTransport defaults to an in-memory LRU store of 1000 entries
+func NewCacheTransport(store HttpCacheStore, transport http.RoundTripper) *CacheTransport {
+	if store == nil {
+		store = NewLruCacheStore(1000)
+	}
+	return &CacheTransport{Transport: transport, Store: store}
+}
+
+// CachingClient returns a client using a cache transport, e.g. for HttpClient
+func CachingClient(store HttpCacheStore) *http.Client {
+	return &http.Client{Transport: NewCacheTransport(store, nil)}
+}
+
+func (t *CacheTransport) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return http.DefaultTransport
+}
+
+func (t *CacheTransport) now() time.Time {
+	if t.Now != nil {
+		return t.Now()
+	}
+	return time.Now()
+}
+
+func (t *CacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	store := t.Store
+	if store == nil || req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return t.transport().RoundTrip(req)
+	}
+
+	key := httpCacheKey(req)
+	reqCc := parseCacheControl(req.Header)
+	if _, ok := reqCc["no-store"]; ok {
+		store.Delete(key)
+		return t.transport().RoundTrip(req)
+	}
+
+	// validators of the caller are for its own copy, not the cached one
+	conditional := req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != ""
+	cached, ok := store.Get(key)
+	if ok && !conditional {
+		_, noCache := reqCc["no-cache"]
+		if !noCache && t.fresh(cached) {
+			return cached.response(req), nil
+		}
+
+		etag, lastMod := cached.Header.Get("ETag"), cached.Header.Get("Last-Modified")
+		if etag != "" || lastMod != "" {
+			req = req.Clone(req.Context())
+			if etag != "" {
+				req.Header.Set("If-None-Match", etag)
+			}
+			if lastMod != "" {
+				req.Header.Set("If-Modified-Since", lastMod)
+			}
+		}
+	}
+
+	res, err := t.transport().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok && !conditional && res.StatusCode == http.StatusNotModified {
+		res.Body.Close()
+		updated := *cached
+		updated.Header = cached.Header.Clone()
+		for k, v := range res.Header {
+			updated.Header[k] = v
+		}
+		updated.StoredAt = t.now()
+		store.Set(key, &updated)
+		return updated.response(req), nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return res, nil
+	}
+
+	resCc := parseCacheControl(res.Header)
+	if _, noStore := resCc["no-store"]; noStore {
+		store.Delete(key)
+		return res, nil
+	}
+	_, hasMaxAge := resCc["max-age"]
+	if !hasMaxAge && res.Header.Get("ETag") == "" && res.Header.Get("Last-Modified") == "" {
+		return res, nil
+	}
+
+	body, err := ReadResponseBody(res)
+	if err != nil {
+		return nil, err
+	}
+	store.Set(key, &CachedResponse{
+		StatusCode: res.StatusCode,
+		Header:     res.Header.Clone(),
+		Body:       body,
+		StoredAt:   t.now(),
+	})
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return res, nil
+}
+
+func (t *CacheTransport) fresh(cached *CachedResponse) bool {
+	cc := parseCacheControl(cached.Header)
+	if _, ok := cc["no-cache"]; ok {
+		return false
+	}
+	maxAge, err := strconv.Atoi(cc["max-age"])
+	if err != nil {
+		return false
+	}
+	return t.now().Sub(cached.StoredAt) < time.Duration(maxAge)*time.Second
+}
+
+func (c *CachedResponse) response(req *http.Request) *http.Response {
+	header := c.Header.Clone()
+	header.Set(HttpCacheHeader, "1")
+	return &http.Response{
+		Status:        strconv.Itoa(c.StatusCode) + " " + http.StatusText(c.StatusCode),
+		StatusCode:    c.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(c.Body)),
+		ContentLength: int64(len(c.Body)),
+		Request:       req,
+	}
+}
+
+func httpCacheKey(req *http.Request) string {
+	key := req.Method + " " + req.URL.String()
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		sum := sha256.Sum256([]byte(auth))
+		key += " " + hex.EncodeToString(sum[:8])
+	}
+	return key
+}
+
+func parseCacheControl(header http.Header) StrMap {
+	cc := StrMap{}
+	for _, v := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(v, ",") {
+			k, val := CutHalf(strings.TrimSpace(part), '=')
+			if k != "" {
+				cc[strings.ToLower(k)] = strings.Trim(val, `"`)
+			}
+		}
+	}
+	return cc
+}
+
+// LruCacheStore
+
+type LruCacheStore struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type lruEntry struct {
+	key string
+	res *CachedResponse
+}
+
+func NewLruCacheStore(capacity int) *LruCacheStore {
+	return &LruCacheStore{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+func (s *LruCacheStore) Get(key string) (*CachedResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.items[key]; ok {
+		s.ll.MoveToFront(e)
+		return e.Value.(*lruEntry).res, true
+	}
+	return nil, false
+}
+
+func (s *LruCacheStore) Set(key string, res *CachedResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.items[key]; ok {
+		e.Value.(*lruEntry).res = res
+		s.ll.MoveToFront(e)
+		return
+	}
+	s.items[key] = s.ll.PushFront(&lruEntry{key, res})
+	for s.capacity > 0 && s.ll.Len() > s.capacity {
+		e := s.ll.Back()
+		s.ll.Remove(e)
+		delete(s.items, e.Value.(*lruEntry).key)
+	}
+}
+
+func (s *LruCacheStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.items[key]; ok {
+		s.ll.Remove(e)
+		delete(s.items, key)
+	}
+}
+
+func (s *LruCacheStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ll.Len()
+}
+
+// DirCacheStore keeps one json file per entry, named by the key hash
+
+type DirCacheStore struct {
+	dir string
+}
+
+func NewDirCacheStore(dir string) (*DirCacheStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DirCacheStore{dir}, nil
+}
+
+func (s *DirCacheStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (s *DirCacheStore) Get(key string) (*CachedResponse, bool) {
+	f, err := os.Open(s.path(key))
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	var res CachedResponse
+	if err := json.NewDecoder(bufio.NewReader(f)).Decode(&res); err != nil {
+		return nil, false
+	}
+	return &res, true
+}
+
+func (s *DirCacheStore) Set(key string, res *CachedResponse) {
+	buf, err := json.Marshal(res)
+	if err != nil {
+		return
+	}
+	// write then rename so readers never see partial files
+	tmp, err := ioutil.TempFile(s.dir, "tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(buf)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || os.Rename(tmp.Name(), s.path(key)) != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+func (s *DirCacheStore) Delete(key string) {
+	os.Remove(s.path(key))
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCacheTransport(t *testing.T) {
+	hits, revalidated := 0, 0
+	version := "v1"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		hits++
+		w.Header().Set("ETag", `"`+version+`"`)
+		switch req.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/nostore":
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		if req.Header.Get("If-None-Match") == `"`+version+`"` {
+			revalidated++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(version + " " + req.URL.Path))
+	}))
+	defer srv.Close()
+
+	now := time.Unix(1600000000, 0)
+	transport := NewCacheTransport(nil, nil)
+	transport.Now = func() time.Time { return now }
+	client := &http.Client{Transport: transport}
+	do := func(path string, header StrMap) (int, string, bool) {
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, string(body), res.Header.Get(HttpCacheHeader) != ""
+	}
+	get := func(path string) (string, bool) {
+		status, body, cached := do(path, nil)
+		if status != http.StatusOK {
+			t.Fatalf("%s: bad status %d", path, status)
+		}
+		return body, cached
+	}
+
+	// fresh for max-age
+	if body, cached := get("/fresh"); body != "v1 /fresh" || cached {
+		t.Fatalf("bad first response %q %v", body, cached)
+	}
+	if body, cached := get("/fresh"); body != "v1 /fresh" || !cached || hits != 1 {
+		t.Fatalf("expected a fresh cached response, got %q %v after %d hits", body, cached, hits)
+	}
+
+	// stale, revalidated with If-None-Match
+	now = now.Add(2 * time.Minute)
+	if body, cached := get("/fresh"); body != "v1 /fresh" || !cached || hits != 2 || revalidated != 1 {
+		t.Fatalf("expected revalidation, got %q %v after %d hits", body, cached, hits)
+	}
+	if _, cached := get("/fresh"); !cached || hits != 2 {
+		t.Fatal("revalidation should refresh the stored time")
+	}
+
+	// no max-age, always revalidated
+	get("/etag")
+	if body, cached := get("/etag"); body != "v1 /etag" || !cached || revalidated != 2 {
+		t.Fatalf("expected revalidation, got %q %v", body, cached)
+	}
+
+	// no-store, never cached
+	get("/nostore")
+	if _, cached := get("/nostore"); cached || revalidated != 2 {
+		t.Fatal("no-store responses should not be cached")
+	}
+
+	// no-cache request forces revalidation
+	req, _ := http.NewRequest("GET", srv.URL+"/fresh", nil)
+	req.Header.Set("Cache-Control", "no-cache")
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if revalidated != 3 {
+		t.Fatal("no-cache requests should revalidate")
+	}
+
+	// validators of the caller get the 304 of the origin, leaving the
+	// cached v1 to be revalidated
+	version = "v2"
+	if status, body, cached := do("/etag", StrMap{"If-None-Match": `"v2"`}); status != http.StatusNotModified || body != "" || cached {
+		t.Fatalf("expected the origin 304, got %d %q %v", status, body, cached)
+	}
+	if body, cached := get("/etag"); body != "v2 /etag" || cached {
+		t.Fatalf("expected the new version, got %q %v", body, cached)
+	}
+
+	// range requests bypass the cache
+	before := hits
+	if status, body, cached := do("/fresh", StrMap{"Range": "bytes=0-1"}); status != http.StatusOK || cached || hits != before+1 || body != "v2 /fresh" {
+		t.Fatalf("range requests should go to the origin, got %d %q %v", status, body, cached)
+	}
+
+	// no store, no caching
+	client.Transport = &CacheTransport{}
+	get("/fresh")
+	if _, cached := get("/fresh"); cached {
+		t.Fatal("nil Store should not cache")
+	}
+}
+
+func TestLruCacheStore(t *testing.T) {
+	s := NewLruCacheStore(2)
+	s.Set("a", &CachedResponse{StatusCode: 1})
+	s.Set("b", &CachedResponse{StatusCode: 2})
+	s.Get("a")
+	s.Set("c", &CachedResponse{StatusCode: 3})
+	if _, ok := s.Get("b"); ok || s.Len() != 2 {
+		t.Fatal("the least recently used entry should be evicted")
+	}
+	if res, ok := s.Get("a"); !ok || res.StatusCode != 1 {
+		t.Fatal("recently used entry should be kept")
+	}
+}
@@ -0,0 +1,361 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HttpCallStats is reported once per call, when the response body is fully
+// read or closed, or when the round trip fails
+type HttpCallStats struct {
+	Method     string
+	Host       string
+	Path       string
+	StatusCode int
+	Err        error
+
+	Start     time.Time
+	DNS       time.Duration
+	Connect   time.Duration
+	TLS       time.Duration
+	FirstByte time.Duration // since Start
+	Body      time.Duration // from first byte to end of body
+	Total     time.Duration
+
+	ReusedConn bool
+	BytesIn    int64 // response body
+	BytesOut   int64 // request body, if known
+}
+
+type HttpObserver interface {
+	ObserveHttpCall(stats *HttpCallStats)
+}
+
+type HttpObserverFunc func(stats *HttpCallStats)
+
+func (f HttpObserverFunc) ObserveHttpCall(stats *HttpCallStats) { f(stats) }
+
+type TracingTransport struct {
+	Transport http.RoundTripper
+	Observer  HttpObserver
+}
+
+func NewTracingTransport(observer HttpObserver, transport http.RoundTripper) *TracingTransport {
+	return &TracingTransport{Transport: transport, Observer: observer}
+}
+
+func (t *TracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ht := &httpTrace{stats: HttpCallStats{
+		Method:   req.Method,
+		Host:     req.URL.Host,
+		Path:     req.URL.Path,
+		Start:    time.Now(),
+		BytesOut: req.ContentLength,
+	}}
+
+	// hooks may run concurrently, e.g. racing dials, even after RoundTrip
+	// returns when the transport keeps a dialed connection for another request
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			ht.update(func(s *HttpCallStats) { ht.dnsStart = time.Now() })
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			ht.update(func(s *HttpCallStats) { s.DNS = time.Since(ht.dnsStart) })
+		},
+		ConnectStart: func(network, addr string) {
+			ht.update(func(s *HttpCallStats) { ht.connStart = time.Now() })
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err == nil {
+				ht.update(func(s *HttpCallStats) { s.Connect = time.Since(ht.connStart) })
+			}
+		},
+		TLSHandshakeStart: func() {
+			ht.update(func(s *HttpCallStats) { ht.tlsStart = time.Now() })
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			ht.update(func(s *HttpCallStats) { s.TLS = time.Since(ht.tlsStart) })
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			ht.update(func(s *HttpCallStats) { s.ReusedConn = info.Reused })
+		},
+		GotFirstResponseByte: func() {
+			ht.update(func(s *HttpCallStats) { s.FirstByte = time.Since(s.Start) })
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		t.observe(ht.finish(err, false))
+		return nil, err
+	}
+
+	ht.update(func(s *HttpCallStats) { s.StatusCode = res.StatusCode })
+	res.Body = &tracedBody{ReadCloser: res.Body, trace: ht, observe: t.observe}
+	return res, nil
+}
+
+func (t *TracingTransport) observe(stats *HttpCallStats) {
+	if t.Observer != nil {
+		t.Observer.ObserveHttpCall(stats)
+	}
+}
+
+type httpTrace struct {
+	mu                            sync.Mutex
+	stats                         HttpCallStats
+	dnsStart, connStart, tlsStart time.Time
+}
+
+func (ht *httpTrace) update(fn func(s *HttpCallStats)) {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+	fn(&ht.stats)
+}
+
+// finish returns a snapshot, which later hooks do not change
+func (ht *httpTrace) finish(err error, body bool) *HttpCallStats {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+	stats := ht.stats
+	stats.Err = err
+	stats.Total = time.Since(stats.Start)
+	if body {
+		stats.Body = stats.Total - stats.FirstByte
+	}
+	return &stats
+}
+
+type tracedBody struct {
+	io.ReadCloser
+	trace   *httpTrace
+	observe func(*HttpCallStats)
+	once    sync.Once
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.trace.update(func(s *HttpCallStats) { s.BytesIn += int64(n) })
+	if err == io.EOF {
+		b.done(nil)
+	} else if err != nil {
+		b.done(err)
+	}
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.done(nil)
+	return err
+}
+
+func (b *tracedBody) done(err error) {
+	b.once.Do(func() {
+		b.observe(b.trace.finish(err, true))
+	})
+}
+
+// histograms
+
+var DefaultHistogramBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64 // cumulative not kept, counts[i] for le buckets[i], last for +Inf
+	count   uint64
+	sum     float64
+}
+
+func NewHistogram(buckets []float64) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultHistogramBuckets
+	}
+	bs := append([]float64(nil), buckets...)
+	sort.Float64s(bs)
+	return &Histogram{buckets: bs, counts: make([]uint64, len(bs)+1)}
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.counts[i]++
+	h.count++
+	h.sum += v
+}
+
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) Sum() float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sum
+}
+
+func (h *Histogram) writeProm(w io.Writer, name, labels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	var cum uint64
+	for i, le := range h.buckets {
+		cum += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep, strconv.FormatFloat(le, 'g', -1, 64), cum)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+// HttpMetrics collects HttpCallStats in memory, by method, host and status
+// code, and serves them in the Prometheus text exposition format
+type HttpMetrics struct {
+	buckets []float64
+
+	mu       sync.Mutex
+	duration map[string]*Histogram // by labels
+	phases   map[string]*Histogram // by labels with phase
+	bytesIn  map[string]int64
+	errors   map[string]int64
+}
+
+func NewHttpMetrics(buckets []float64) *HttpMetrics {
+	return &HttpMetrics{
+		buckets:  buckets,
+		duration: map[string]*Histogram{},
+		phases:   map[string]*Histogram{},
+		bytesIn:  map[string]int64{},
+		errors:   map[string]int64{},
+	}
+}
+
+func (m *HttpMetrics) histogram(hs map[string]*Histogram, labels string) *Histogram {
+	h, ok := hs[labels]
+	if !ok {
+		h = NewHistogram(m.buckets)
+		hs[labels] = h
+	}
+	return h
+}
+
+func (m *HttpMetrics) ObserveHttpCall(stats *HttpCallStats) {
+	code := "error"
+	if stats.StatusCode > 0 {
+		code = strconv.Itoa(stats.StatusCode)
+	}
+	labels := promLabels("method", stats.Method, "host", stats.Host, "code", code)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.histogram(m.duration, labels).Observe(stats.Total.Seconds())
+	phases := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"dns", stats.DNS}, {"connect", stats.Connect}, {"tls", stats.TLS},
+		{"first_byte", stats.FirstByte}, {"body", stats.Body},
+	}
+	for _, p := range phases {
+		if p.d > 0 {
+			pl := promLabels("method", stats.Method, "host", stats.Host, "phase", p.name)
+			m.histogram(m.phases, pl).Observe(p.d.Seconds())
+		}
+	}
+	m.bytesIn[labels] += stats.BytesIn
+	if stats.Err != nil {
+		m.errors[promLabels("method", stats.Method, "host", stats.Host)]++
+	}
+}
+
+func (m *HttpMetrics) WriteProm(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name := "http_client_request_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Duration of HTTP client calls.\n# TYPE %s histogram\n", name, name)
+	for _, labels := range sortedHistogramKeys(m.duration) {
+		m.duration[labels].writeProm(w, name, labels)
+	}
+
+	name = "http_client_phase_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Duration of HTTP client call phases.\n# TYPE %s histogram\n", name, name)
+	for _, labels := range sortedHistogramKeys(m.phases) {
+		m.phases[labels].writeProm(w, name, labels)
+	}
+
+	name = "http_client_response_bytes_total"
+	fmt.Fprintf(w, "# HELP %s Response body bytes read.\n# TYPE %s counter\n", name, name)
+	for _, labels := range sortedCounterKeys(m.bytesIn) {
+		fmt.Fprintf(w, "%s{%s} %d\n", name, labels, m.bytesIn[labels])
+	}
+
+	name = "http_client_errors_total"
+	fmt.Fprintf(w, "# HELP %s HTTP client calls failed without complete response.\n# TYPE %s counter\n", name, name)
+	for _, labels := range sortedCounterKeys(m.errors) {
+		fmt.Fprintf(w, "%s{%s} %d\n", name, labels, m.errors[labels])
+	}
+}
+
+func (m *HttpMetrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteProm(w)
+}
+
+func promLabels(kvs ...string) string {
+	var sb strings.Builder
+	for i := 0; i+1 < len(kvs); i += 2 {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(kvs[i])
+		sb.WriteString(`="`)
+		sb.WriteString(promLabelEscaper.Replace(kvs[i+1]))
+		sb.WriteByte('"')
+	}
+	return sb.String()
+}
+
+// label values escape only backslash, double quote and line feed
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func sortedHistogramKeys(m map[string]*Histogram) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedCounterKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// run with -race: trace hooks, body reads and observers run concurrently
+func TestTracingTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	metrics := NewHttpMetrics(nil)
+	var mu sync.Mutex
+	var calls []HttpCallStats
+	observer := HttpObserverFunc(func(stats *HttpCallStats) {
+		metrics.ObserveHttpCall(stats)
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, *stats)
+	})
+	client := &http.Client{Transport: NewTracingTransport(observer, &http.Transport{})}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := client.Get(srv.URL + "/x")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ioutil.ReadAll(res.Body)
+			res.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	if _, err := client.Get("http://127.0.0.1:1/"); err == nil {
+		t.Fatal("expected connection error")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) != 21 {
+		t.Fatalf("expected 21 observed calls, got %d", len(calls))
+	}
+	for _, c := range calls[:20] {
+		if c.StatusCode != 200 || c.BytesIn != 5 || c.Path != "/x" || c.Err != nil || c.Total < c.FirstByte {
+			t.Fatalf("bad stats %+v", c)
+		}
+	}
+	if calls[20].Err == nil || calls[20].StatusCode != 0 {
+		t.Fatalf("bad error stats %+v", calls[20])
+	}
+
+	var buf bytes.Buffer
+	metrics.WriteProm(&buf)
+	prom := buf.String()
+	host := strings.TrimPrefix(srv.URL, "http://")
+	for _, want := range []string{
+		`http_client_request_duration_seconds_count{method="GET",host="` + host + `",code="200"} 20`,
+		`http_client_response_bytes_total{method="GET",host="` + host + `",code="200"} 100`,
+		`http_client_errors_total{method="GET",host="127.0.0.1:1"} 1`,
+	} {
+		if !strings.Contains(prom, want) {
+			t.Fatalf("missing %s in\n%s", want, prom)
+		}
+	}
+	if s := promLabels("host", "a\\b\"c\nd\té"); s != `host="a\\b\"c\nd`+"\té"+`"` {
+		t.Fatalf("bad label escaping %s", s)
+	}
+}
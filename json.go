@@ -4,6 +4,7 @@ package goutil
 
 import (
 	"encoding/json"
+	"fmt"
 	"reflect"
 )
 
@@ -48,11 +49,45 @@ type JsonMsg struct {
 	Data       `json:"data,omitempty"`
 	Error      `json:"error,omitempty"`
 }
+
+// Format prints the message as json, indented with %+v; otherwise JsonMsg
+// would print as its embedded Error
+func (msg JsonMsg) Format(s fmt.State, verb rune) {
+	var buf []byte
+	var err error
+	if s.Flag('+') {
+		buf, err = json.MarshalIndent(msg, "", "  ")
+	} else {
+		buf, err = json.Marshal(msg)
+	}
+	switch {
+	case err != nil:
+		fmt.Fprintf(s, "%%!%c(goutil.JsonMsg=%s)", verb, err)
+	case verb == 'v' || verb == 's':
+		s.Write(buf)
+	case verb == 'q':
+		fmt.Fprintf(s, "%q", buf)
+	default:
+		fmt.Fprintf(s, "%%!%c(goutil.JsonMsg=%s)", verb, buf)
+	}
+}
+
 type Data struct {
 	Kind    string            `json:"kind,omitempty"`
 	Payload string            `json:"payload,omitempty"` // make it simple - not using Fields
 	Values  map[string]string `json:"values,omitempty"`  // make it simple - not using Fields
 	Items   []json.RawMessage `json:"items,omitempty"`
+
+	// paging, see SetPage in paging.go; indexes are 1-based
+	CurrentItemCount int    `json:"currentItemCount,omitempty"`
+	ItemsPerPage     int    `json:"itemsPerPage,omitempty"`
+	StartIndex       int    `json:"startIndex,omitempty"`
+	TotalItems       int    `json:"totalItems,omitempty"`
+	PageIndex        int    `json:"pageIndex,omitempty"`
+	TotalPages       int    `json:"totalPages,omitempty"`
+	SelfLink         string `json:"selfLink,omitempty"`
+	NextLink         string `json:"nextLink,omitempty"`
+	PreviousLink     string `json:"previousLink,omitempty"`
 }
 
 func (d *Data) AddItem(item interface{}) error {
@@ -0,0 +1,253 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	JsonDiffValue      = "value"      // different values
+	JsonDiffType       = "type"       // e.g. object vs array
+	JsonDiffMissing    = "missing"    // expected but not in actual
+	JsonDiffUnexpected = "unexpected" // in actual but not expected
+)
+
+// JsonDifference is reported at a JSON pointer path
+type JsonDifference struct {
+	Path     string
+	Kind     string
+	Expected interface{}
+	Actual   interface{}
+}
+
+func (d JsonDifference) String() string {
+	path := d.Path
+	if path == "" {
+		path = "(root)"
+	}
+	switch d.Kind {
+	case JsonDiffMissing:
+		return fmt.Sprintf("%s: missing, expected %s", path, jsonDiffValue(d.Expected))
+	case JsonDiffUnexpected:
+		return fmt.Sprintf("%s: unexpected %s", path, jsonDiffValue(d.Actual))
+	}
+	return fmt.Sprintf("%s: expected %s, actual %s", path, jsonDiffValue(d.Expected), jsonDiffValue(d.Actual))
+}
+
+func jsonDiffValue(v interface{}) string {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	if len(buf) > 80 {
+		return string(buf[:77]) + "..."
+	}
+	return string(buf)
+}
+
+type JsonDiffs []JsonDifference
+
+// String reports one difference per line
+func (ds JsonDiffs) String() string {
+	lines := make([]string, len(ds))
+	for i, d := range ds {
+		lines[i] = d.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+type JsonDiffOptions struct {
+	// Contains lets actual have extra object members, as in ContainsRecursive;
+	// arrays must still match exactly, including objects within them
+	Contains bool
+	// Ignore lists JSON pointers whose subtrees are skipped, with "*" matching
+	// any token, e.g. "/items/*/id"
+	Ignore []string
+	// Tolerance is the maximum absolute difference of equal numbers
+	Tolerance float64
+}
+
+// JsonDiff reports all differences between expected and actual, which may be
+// decoded JSON or Go values as compared by ContainsRecursive; object members
+// are visited in sorted key order
+func JsonDiff(expected, actual interface{}, opts ...JsonDiffOptions) JsonDiffs {
+	var opt JsonDiffOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	d := jsonDiffer{opt: opt}
+	for _, ptr := range opt.Ignore {
+		if tokens, err := ParseJsonPointer(ptr); err == nil {
+			d.ignore = append(d.ignore, tokens)
+		}
+	}
+	d.diff(nil, expected, actual, opt.Contains)
+	return d.diffs
+}
+
+func JsonDiffBytes(expected, actual []byte, opts ...JsonDiffOptions) (JsonDiffs, error) {
+	var e, a interface{}
+	if err := json.Unmarshal(expected, &e); err != nil {
+		return nil, fmt.Errorf("Expected: %w", err)
+	}
+	if err := json.Unmarshal(actual, &a); err != nil {
+		return nil, fmt.Errorf("Actual: %w", err)
+	}
+	return JsonDiff(e, a, opts...), nil
+}
+
+func JsonStrDiff(expected, actual string, opts ...JsonDiffOptions) (JsonDiffs, error) {
+	return JsonDiffBytes([]byte(expected), []byte(actual), opts...)
+}
+
+// AssertJsonEqual reports differences of JSON values, or of JSON strings or
+// []byte if both are, with t.Errorf
+func AssertJsonEqual(t TestingT, expected, actual interface{}, opts ...JsonDiffOptions) bool {
+	t.Helper()
+	var diffs JsonDiffs
+	var err error
+	switch e := expected.(type) {
+	case string:
+		if a, ok := actual.(string); ok {
+			diffs, err = JsonStrDiff(e, a, opts...)
+		} else {
+			diffs = JsonDiff(expected, actual, opts...)
+		}
+	case []byte:
+		if a, ok := actual.([]byte); ok {
+			diffs, err = JsonDiffBytes(e, a, opts...)
+		} else {
+			diffs = JsonDiff(expected, actual, opts...)
+		}
+	default:
+		diffs = JsonDiff(expected, actual, opts...)
+	}
+	if err != nil {
+		t.Errorf("%v", err)
+		return false
+	}
+	if len(diffs) > 0 {
+		t.Errorf("JSON differences:\n%s", diffs)
+		return false
+	}
+	return true
+}
+
+type jsonDiffer struct {
+	opt    JsonDiffOptions
+	ignore [][]string
+	diffs  JsonDiffs
+}
+
+func (d *jsonDiffer) ignored(tokens []string) bool {
+	for _, ig := range d.ignore {
+		if len(ig) > len(tokens) {
+			continue
+		}
+		match := true
+		for i, tok := range ig {
+			if tok != "*" && tok != tokens[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *jsonDiffer) add(tokens []string, kind string, expected, actual interface{}) {
+	d.diffs = append(d.diffs, JsonDifference{JsonPointer(tokens...), kind, expected, actual})
+}
+
+// contains is turned off within arrays
+func (d *jsonDiffer) diff(tokens []string, expected, actual interface{}, contains bool) {
+	if d.ignored(tokens) {
+		return
+	}
+	child := func(key string) []string {
+		return append(append([]string(nil), tokens...), key)
+	}
+
+	ek, ak := jsonKind(expected), jsonKind(actual)
+	if ek != ak {
+		d.add(tokens, JsonDiffType, expected, actual)
+		return
+	}
+
+	switch ek {
+	case "object":
+		ekeys, _ := jsonChildren(expected)
+		akeys, _ := jsonChildren(actual)
+		keys := append(ekeys, akeys...)
+		sort.Strings(keys)
+		for i, k := range keys {
+			if i > 0 && k == keys[i-1] {
+				continue
+			}
+			ev, inExpected := jsonChild(expected, k)
+			av, inActual := jsonChild(actual, k)
+			switch {
+			case inExpected && inActual:
+				d.diff(child(k), ev, av, contains)
+			case d.ignored(child(k)):
+			case inExpected:
+				d.add(child(k), JsonDiffMissing, ev, nil)
+			case !contains:
+				d.add(child(k), JsonDiffUnexpected, nil, av)
+			}
+		}
+
+	case "array":
+		ekeys, evals := jsonChildren(expected)
+		akeys, avals := jsonChildren(actual)
+		for i := range evals {
+			if i < len(avals) {
+				d.diff(child(ekeys[i]), evals[i], avals[i], false)
+			} else if !d.ignored(child(ekeys[i])) {
+				d.add(child(ekeys[i]), JsonDiffMissing, evals[i], nil)
+			}
+		}
+		for i := len(evals); i < len(avals); i++ {
+			if !d.ignored(child(akeys[i])) {
+				d.add(child(akeys[i]), JsonDiffUnexpected, nil, avals[i])
+			}
+		}
+
+	default:
+		if !d.equal(expected, actual) {
+			kind := JsonDiffValue
+			if jsonDiffBasicType(expected) != jsonDiffBasicType(actual) {
+				kind = JsonDiffType
+			}
+			d.add(tokens, kind, expected, actual)
+		}
+	}
+}
+
+func (d *jsonDiffer) equal(expected, actual interface{}) bool {
+	if ef, ok := jsonNumber(expected); ok {
+		if af, ok := jsonNumber(actual); ok {
+			return ef == af || math.Abs(ef-af) <= d.opt.Tolerance
+		}
+	}
+	return reflect.DeepEqual(expected, actual)
+}
+
+func jsonDiffBasicType(v interface{}) string {
+	if _, ok := jsonNumber(v); ok {
+		return "number"
+	}
+	if v == nil {
+		return "null"
+	}
+	return reflect.TypeOf(v).Kind().String()
+}
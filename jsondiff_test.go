@@ -0,0 +1,93 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestJsonDiff(t *testing.T) {
+	expected := `{"id": 1, "name": "a", "tags": ["x", "y"], "meta": {"score": 1.0, "at": "now"}, "gone": true}`
+	actual := `{"id": 1, "name": "b", "tags": ["x", "y", "z"], "meta": {"score": 1.001, "at": "later"}, "extra": null}`
+
+	diffs, err := JsonStrDiff(expected, actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `/extra: unexpected null
+/gone: missing, expected true
+/meta/at: expected "now", actual "later"
+/meta/score: expected 1, actual 1.001
+/name: expected "a", actual "b"
+/tags/2: unexpected "z"`
+	if diffs.String() != want {
+		t.Fatalf("bad report:\n%s", diffs)
+	}
+
+	diffs, _ = JsonStrDiff(expected, actual, JsonDiffOptions{
+		Contains:  true,
+		Ignore:    []string{"/meta/at", "/gone", "/tags/*"},
+		Tolerance: 0.01,
+	})
+	if diffs.String() != `/name: expected "a", actual "b"` {
+		t.Fatalf("bad options report:\n%s", diffs)
+	}
+
+	if diffs, _ = JsonStrDiff(`{"a": [1]}`, `{"a": {"0": 1}}`); len(diffs) != 1 || diffs[0].Kind != JsonDiffType {
+		t.Fatalf("expected type difference, got %v", diffs)
+	}
+	if diffs, _ = JsonStrDiff(`{"a": 1}`, `{"a": "1"}`); len(diffs) != 1 || diffs[0].Kind != JsonDiffType {
+		t.Fatalf("expected type difference, got %v", diffs)
+	}
+	if diffs = JsonDiff(1, 2); diffs.String() != "(root): expected 1, actual 2" {
+		t.Fatalf("bad root report %s", diffs)
+	}
+
+	// Contains agrees with JsonContains, arrays included
+	var containTests = []struct {
+		expected, actual string
+	}{
+		{`{"a": 1}`, `{"a": 1, "b": 2}`},
+		{`{"a": {"x": 1}}`, `{"a": {"x": 1, "y": 2}}`},
+		{`{"a": [{"x": 1}]}`, `{"a": [{"x": 1, "y": 2}]}`},
+		{`{"a": [1, 2]}`, `{"a": [1, 2]}`},
+		{`{"a": [1]}`, `{"a": [1, 2]}`},
+		{`{"a": 1, "b": 2}`, `{"a": 1}`},
+	}
+	for _, tt := range containTests {
+		diffs, _ := JsonStrDiff(tt.expected, tt.actual, JsonDiffOptions{Contains: true})
+		if (len(diffs) == 0) != JsonStrContains(tt.actual, tt.expected) {
+			t.Fatalf("%s in %s: inconsistent with JsonContains: %s", tt.expected, tt.actual, diffs)
+		}
+	}
+
+	// Go values
+	m1 := Map{"a": 1, "b": []Map{{"c": 2, "d": 3}}, "e": "extra"}
+	m2 := Map{"a": 1.0, "b": []interface{}{map[string]interface{}{"c": 2}}}
+	if diffs = JsonDiff(m2, m1, JsonDiffOptions{Contains: true}); diffs.String() != "/b/0/d: unexpected 3" {
+		t.Fatalf("bad Go value report %s", diffs)
+	}
+	if diffs = JsonDiff(m2, m1); diffs.String() != "/b/0/d: unexpected 3\n/e: unexpected \"extra\"" {
+		t.Fatalf("bad Go value report %s", diffs)
+	}
+}
+
+type recordingT struct {
+	errors []string
+}
+
+func (r *recordingT) Helper() {}
+func (r *recordingT) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertJsonEqual(t *testing.T) {
+	rt := &recordingT{}
+	if !AssertJsonEqual(rt, `{"a": [1, 2]}`, `{"a":[1,2]}`) || len(rt.errors) != 0 {
+		t.Fatal("equal JSON should pass")
+	}
+	if AssertJsonEqual(rt, []byte(`{"a": 1}`), []byte(`{"a": 2}`)) || rt.errors[0] != "JSON differences:\n/a: expected 1, actual 2" {
+		t.Fatalf("bad assertion report %v", rt.errors)
+	}
+}
@@ -0,0 +1,455 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// REF: https://www.rfc-editor.org/rfc/rfc6902 and https://www.rfc-editor.org/rfc/rfc7386
+// documents are normalized through encoding/json first, so Go values like
+// Map{"n": 1} work as well, and are never modified
+
+var (
+	ErrJsonPatchOp   = errors.New("Invalid JSON patch operation")
+	ErrJsonPatchTest = errors.New("JSON patch test failed")
+)
+
+type JsonPatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value"`
+}
+
+// MarshalJSON only includes value for add, replace and test
+func (op JsonPatchOp) MarshalJSON() ([]byte, error) {
+	m := Map{"op": op.Op, "path": op.Path}
+	switch op.Op {
+	case "add", "replace", "test":
+		m["value"] = op.Value
+	case "move", "copy":
+		m["from"] = op.From
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON requires value for add, replace and test, and from for move
+// and copy, as a null value differs from a missing one
+func (op *JsonPatchOp) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		From  *string         `json:"from"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*op = JsonPatchOp{Op: raw.Op, Path: raw.Path}
+	switch raw.Op {
+	case "add", "replace", "test":
+		if raw.Value == nil {
+			return fmt.Errorf("%w: %s %s without value", ErrJsonPatchOp, raw.Op, raw.Path)
+		}
+		return json.Unmarshal(raw.Value, &op.Value)
+	case "move", "copy":
+		if raw.From == nil {
+			return fmt.Errorf("%w: %s %s without from", ErrJsonPatchOp, raw.Op, raw.Path)
+		}
+		op.From = *raw.From
+	}
+	return nil
+}
+
+type JsonPatch []JsonPatchOp
+
+func ParseJsonPatch(data []byte) (JsonPatch, error) {
+	var p JsonPatch
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Apply applies the operations in order to a copy of doc; nothing is
+// applied if any fails
+func (p JsonPatch) Apply(doc interface{}) (interface{}, error) {
+	cur, err := jsonNormalize(doc)
+	if err != nil {
+		return nil, err
+	}
+	for i, op := range p {
+		if cur, err = op.apply(cur); err != nil {
+			return nil, fmt.Errorf("Patch operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return cur, nil
+}
+
+func (p JsonPatch) ApplyBytes(doc []byte) ([]byte, error) {
+	var v interface{}
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return nil, err
+	}
+	ret, err := p.Apply(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(ret)
+}
+
+// ApplyMap fails if the result is not an object
+func (p JsonPatch) ApplyMap(m Map) (Map, error) {
+	ret, err := p.Apply(m)
+	if err != nil {
+		return nil, err
+	}
+	return jsonResultMap(ret)
+}
+
+func jsonResultMap(v interface{}) (Map, error) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return Map(m), nil
+	case nil:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("Result is %T, not an object", v)
+}
+
+func (op JsonPatchOp) apply(doc interface{}) (interface{}, error) {
+	tokens, err := ParseJsonPointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		val, err := jsonNormalize(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		switch op.Op {
+		case "add":
+			return jsonAdd(doc, tokens, val)
+		case "replace":
+			if len(tokens) == 0 {
+				return val, nil
+			}
+			return jsonUpdatePath(doc, tokens, tokens, func(parent interface{}, path []string) (interface{}, error) {
+				return jsonSetChild(parent, path, val, false)
+			})
+		}
+		cur, err := JsonPointerGet(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(cur, val) {
+			return nil, ErrJsonPatchTest
+		}
+		return doc, nil
+
+	case "remove":
+		if len(tokens) == 0 {
+			return nil, nil
+		}
+		return jsonUpdatePath(doc, tokens, tokens, jsonRemoveChild)
+
+	case "move", "copy":
+		val, err := JsonPointerGet(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if op.From == op.Path {
+				return doc, nil
+			}
+			if strings.HasPrefix(op.Path, op.From+"/") {
+				return nil, fmt.Errorf("%w: cannot move %s into itself", ErrJsonPatchOp, op.From)
+			}
+			if doc, err = JsonPointerDelete(doc, op.From); err != nil {
+				return nil, err
+			}
+		} else if val, err = jsonNormalize(val); err != nil { // deep copy
+			return nil, err
+		}
+		return jsonAdd(doc, tokens, val)
+	}
+	return nil, fmt.Errorf("%w %q", ErrJsonPatchOp, op.Op)
+}
+
+func jsonAdd(doc interface{}, tokens []string, val interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return val, nil
+	}
+	return jsonUpdatePath(doc, tokens, tokens, func(parent interface{}, path []string) (interface{}, error) {
+		return jsonInsertChild(parent, path, val)
+	})
+}
+
+// jsonNormalize deep copies v into the types decoded by encoding/json
+func jsonNormalize(v interface{}) (interface{}, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var ret interface{}
+	err = json.Unmarshal(buf, &ret)
+	return ret, err
+}
+
+// ApplyJsonPatch applies a JSON patch document to a JSON document
+func ApplyJsonPatch(doc, patch []byte) ([]byte, error) {
+	p, err := ParseJsonPatch(patch)
+	if err != nil {
+		return nil, err
+	}
+	return p.ApplyBytes(doc)
+}
+
+// CreateJsonPatch generates a patch that turns from into to, with add,
+// remove and replace operations; arrays are diffed along their longest
+// common subsequence, quadratic in the length of the differing middle
+func CreateJsonPatch(from, to interface{}) (JsonPatch, error) {
+	a, err := jsonNormalize(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := jsonNormalize(to)
+	if err != nil {
+		return nil, err
+	}
+	return diffJsonPatch(nil, a, b, nil), nil
+}
+
+func CreateJsonPatchBytes(from, to []byte) ([]byte, error) {
+	var a, b interface{}
+	if err := json.Unmarshal(from, &a); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(to, &b); err != nil {
+		return nil, err
+	}
+	p := diffJsonPatch(nil, a, b, nil)
+	if p == nil {
+		p = JsonPatch{}
+	}
+	return json.Marshal(p)
+}
+
+func diffJsonPatch(p JsonPatch, a, b interface{}, tokens []string) JsonPatch {
+	if reflect.DeepEqual(a, b) {
+		return p
+	}
+	path := JsonPointer(tokens...)
+	child := func(key string) []string {
+		return append(append([]string(nil), tokens...), key)
+	}
+
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys, _ := jsonMapChildren(av)
+		for _, k := range keys {
+			if _, ok := bv[k]; !ok {
+				p = append(p, JsonPatchOp{Op: "remove", Path: JsonPointer(child(k)...)})
+			}
+		}
+		for _, k := range keys {
+			if bval, ok := bv[k]; ok {
+				p = diffJsonPatch(p, av[k], bval, child(k))
+			}
+		}
+		keys, vals := jsonMapChildren(bv)
+		for i, k := range keys {
+			if _, ok := av[k]; !ok {
+				p = append(p, JsonPatchOp{Op: "add", Path: JsonPointer(child(k)...), Value: vals[i]})
+			}
+		}
+		return p
+
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		start := 0
+		for start < len(av) && start < len(bv) && reflect.DeepEqual(av[start], bv[start]) {
+			start++
+		}
+		endA, endB := len(av), len(bv)
+		for endA > start && endB > start && reflect.DeepEqual(av[endA-1], bv[endB-1]) {
+			endA--
+			endB--
+		}
+		return diffJsonArray(p, av[start:endA], bv[start:endB], start, child)
+	}
+	return append(p, JsonPatchOp{Op: "replace", Path: path, Value: b})
+}
+
+// diffJsonArray follows a longest common subsequence of a and b, starting
+// at index pos; elements removed and added between common ones are paired
+// up and diffed in place, leaving the rest as removes or adds
+func diffJsonArray(p JsonPatch, a, b []interface{}, pos int, child func(string) []string) JsonPatch {
+	m, n := len(a), len(b)
+	lcs := make([][]int, m+1) // lcs[i][j] for a[i:] and b[j:]
+	for i := range lcs {
+		lcs[i] = make([]int, n+1)
+	}
+	for i := m - 1; i >= 0; i-- {
+		for j := n - 1; j >= 0; j-- {
+			if reflect.DeepEqual(a[i], b[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < m || j < n {
+		i0, j0 := i, j
+		for i < m || j < n {
+			if i < m && j < n && reflect.DeepEqual(a[i], b[j]) {
+				break
+			}
+			if j == n || (i < m && lcs[i+1][j] >= lcs[i][j+1]) {
+				i++
+			} else {
+				j++
+			}
+		}
+
+		removed, added := a[i0:i], b[j0:j]
+		k := 0
+		for ; k < len(removed) && k < len(added); k++ {
+			p = diffJsonPatch(p, removed[k], added[k], child(strconv.Itoa(pos+k)))
+		}
+		for r := k; r < len(removed); r++ {
+			p = append(p, JsonPatchOp{Op: "remove", Path: JsonPointer(child(strconv.Itoa(pos + k))...)})
+		}
+		for ; k < len(added); k++ {
+			p = append(p, JsonPatchOp{Op: "add", Path: JsonPointer(child(strconv.Itoa(pos + k))...), Value: added[k]})
+		}
+		pos += len(added)
+
+		if i < m && j < n { // common element
+			i++
+			j++
+			pos++
+		}
+	}
+	return p
+}
+
+// merge patch
+
+// MergePatch applies an RFC 7386 merge patch to a copy of doc
+func MergePatch(doc, patch interface{}) (interface{}, error) {
+	d, err := jsonNormalize(doc)
+	if err != nil {
+		return nil, err
+	}
+	p, err := jsonNormalize(patch)
+	if err != nil {
+		return nil, err
+	}
+	return mergePatch(d, p), nil
+}
+
+func mergePatch(doc, patch interface{}) interface{} {
+	pm, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	dm, ok := doc.(map[string]interface{})
+	if !ok {
+		dm = map[string]interface{}{}
+	}
+	for k, v := range pm {
+		if v == nil {
+			delete(dm, k)
+		} else {
+			dm[k] = mergePatch(dm[k], v)
+		}
+	}
+	return dm
+}
+
+func MergePatchBytes(doc, patch []byte) ([]byte, error) {
+	var d, p interface{}
+	if err := json.Unmarshal(doc, &d); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergePatch(d, p))
+}
+
+func MergePatchMap(m, patch Map) (Map, error) {
+	ret, err := MergePatch(m, patch)
+	if err != nil {
+		return nil, err
+	}
+	return jsonResultMap(ret)
+}
+
+// CreateMergePatch generates a merge patch that turns from into to; note
+// that merge patches cannot set null values
+func CreateMergePatch(from, to interface{}) (interface{}, error) {
+	a, err := jsonNormalize(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := jsonNormalize(to)
+	if err != nil {
+		return nil, err
+	}
+	return createMergePatch(a, b), nil
+}
+
+func createMergePatch(a, b interface{}) interface{} {
+	am, ok1 := a.(map[string]interface{})
+	bm, ok2 := b.(map[string]interface{})
+	if !ok1 || !ok2 {
+		return b
+	}
+	ret := map[string]interface{}{}
+	for k := range am {
+		if _, ok := bm[k]; !ok {
+			ret[k] = nil
+		}
+	}
+	for k, bv := range bm {
+		av, ok := am[k]
+		if !ok {
+			ret[k] = bv
+		} else if !reflect.DeepEqual(av, bv) {
+			ret[k] = createMergePatch(av, bv)
+		}
+	}
+	return ret
+}
+
+func CreateMergePatchBytes(from, to []byte) ([]byte, error) {
+	var a, b interface{}
+	if err := json.Unmarshal(from, &a); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(to, &b); err != nil {
+		return nil, err
+	}
+	return json.Marshal(createMergePatch(a, b))
+}
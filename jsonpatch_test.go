@@ -0,0 +1,155 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestJsonPatchApply(t *testing.T) {
+	// mostly from RFC 6902 appendix A
+	var tests = []struct {
+		doc, patch, want string
+	}{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{`{"baz":"qux","foo":["a",2,"c"]}`,
+			`[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		{`{"foo":{"a":1}}`, `[{"op":"copy","from":"/foo","path":"/bar"},{"op":"replace","path":"/bar/a","value":2}]`, `{"foo":{"a":1},"bar":{"a":2}}`},
+		{`{"foo":1}`, `[{"op":"replace","path":"","value":[1]}]`, `[1]`},
+		{`{"foo":null}`, `[{"op":"test","path":"/foo","value":null}]`, `{"foo":null}`},
+		{`{"foo":1}`, `[{"op":"add","path":"/bar","value":null}]`, `{"foo":1,"bar":null}`},
+	}
+	for _, tt := range tests {
+		got, err := ApplyJsonPatch([]byte(tt.doc), []byte(tt.patch))
+		if err != nil || !JsonEqual(got, []byte(tt.want)) {
+			t.Fatalf("%s: expected %s, got %s %v", tt.patch, tt.want, got, err)
+		}
+	}
+
+	var failures = []struct {
+		doc, patch string
+		err        error
+	}{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, ErrJsonPointerNotFound},
+		{`{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, ErrJsonPatchTest},
+		{`{"foo":["a"]}`, `[{"op":"add","path":"/foo/2","value":"b"}]`, ErrJsonPointerNotFound},
+		{`{"foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"qux"}]`, ErrJsonPointerNotFound},
+		{`{"foo":{"a":1}}`, `[{"op":"move","from":"/foo","path":"/foo/b"}]`, ErrJsonPatchOp},
+		{`{"foo":"bar"}`, `[{"op":"bogus","path":"/foo"}]`, ErrJsonPatchOp},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz"}]`, ErrJsonPatchOp},
+		{`{"foo":"bar"}`, `[{"op":"replace","path":"/foo"}]`, ErrJsonPatchOp},
+		{`{"foo":"bar"}`, `[{"op":"test","path":"/foo"}]`, ErrJsonPatchOp},
+		{`{"foo":"bar"}`, `[{"op":"copy","path":"/baz"}]`, ErrJsonPatchOp},
+	}
+	for _, tt := range failures {
+		if _, err := ApplyJsonPatch([]byte(tt.doc), []byte(tt.patch)); !errors.Is(err, tt.err) {
+			t.Fatalf("%s: expected %v, got %v", tt.patch, tt.err, err)
+		}
+	}
+
+	m := Map{"n": 1, "list": []Map{{"a": 1}}}
+	patch := JsonPatch{{Op: "add", Path: "/list/0/b", Value: 2}, {Op: "test", Path: "/n", Value: 1}}
+	got, err := patch.ApplyMap(m)
+	if err != nil || !JsonMarshalContains(got, Map{"list": []Map{{"a": 1, "b": 2}}}) {
+		t.Fatalf("bad map patch %v %v", got, err)
+	}
+	if len(m["list"].([]Map)[0]) != 1 {
+		t.Fatal("the original should not be modified")
+	}
+}
+
+func TestJsonPatchCreate(t *testing.T) {
+	var tests = []struct {
+		from, to string
+		ops      int
+	}{
+		{`{"a":1}`, `{"a":1}`, 0},
+		{`{"a":1,"b":{"c":2,"d":3}}`, `{"a":1,"b":{"c":4},"e":5}`, 3},
+		{`[1,2,3,4]`, `[1,9,2,3,4]`, 1},
+		{`[1,2,3,4]`, `[1,4]`, 2},
+		{`[1,2,3]`, `[1,5,3]`, 1},
+		{`[1,2,3,4,5]`, `[1,9,2,3,4]`, 2},
+		{`[1,2,3,4]`, `[1,9,4,5,6]`, 4},
+		{`["a","b","c","d"]`, `["c","d","a","b"]`, 4},
+		{`[1,2,3,4,5,6]`, `[2,3,7,5,6,8]`, 3},
+		{`[]`, `[1,2]`, 2},
+		{`[1,2]`, `[]`, 2},
+		{`[{"x":1},2,3]`, `[2,3,{"x":1,"y":2}]`, 2},
+		{`{"a":[{"x":1},{"x":2}]}`, `{"a":[{"x":1},{"x":3},{"x":4}]}`, 2},
+		{`{"a":"s"}`, `[1]`, 1},
+	}
+	for _, tt := range tests {
+		buf, err := CreateJsonPatchBytes([]byte(tt.from), []byte(tt.to))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, _ := ParseJsonPatch(buf)
+		if len(p) != tt.ops {
+			t.Fatalf("%s -> %s: expected %d ops, got %s", tt.from, tt.to, tt.ops, buf)
+		}
+		got, err := ApplyJsonPatch([]byte(tt.from), buf)
+		if err != nil || !JsonEqual(got, []byte(tt.to)) {
+			t.Fatalf("%s -> %s: patch %s gives %s %v", tt.from, tt.to, buf, got, err)
+		}
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	// from RFC 7386 appendix A
+	var tests = []struct {
+		doc, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		got, err := MergePatchBytes([]byte(tt.doc), []byte(tt.patch))
+		if err != nil || !JsonEqual(got, []byte(tt.want)) {
+			t.Fatalf("%s + %s: expected %s, got %s %v", tt.doc, tt.patch, tt.want, got, err)
+		}
+	}
+
+	from := `{"title":"Goodbye!","author":{"givenName":"John","familyName":"Doe"},"tags":["example","sample"],"content":"This will be unchanged"}`
+	to := `{"title":"Hello!","author":{"givenName":"John"},"tags":["example"],"content":"This will be unchanged","phoneNumber":"+01-123-456-7890"}`
+	patch, err := CreateMergePatchBytes([]byte(from), []byte(to))
+	if err != nil || !JsonEqual(patch, []byte(`{"title":"Hello!","author":{"familyName":null},"tags":["example"],"phoneNumber":"+01-123-456-7890"}`)) {
+		t.Fatalf("bad merge patch %s %v", patch, err)
+	}
+	if got, _ := MergePatchBytes([]byte(from), patch); !JsonEqual(got, []byte(to)) {
+		t.Fatalf("merge patch does not round-trip: %s", got)
+	}
+
+	m := Map{"a": 1, "b": Map{"c": 2}}
+	got, err := MergePatchMap(m, Map{"b": Map{"c": nil, "d": 3}})
+	if err != nil || !JsonMarshalContains(got, Map{"a": 1, "b": Map{"d": 3}}) || got["b"].(map[string]interface{})["c"] != nil {
+		t.Fatalf("bad map merge %v %v", got, err)
+	}
+	if m["b"].(Map)["c"] != 2 {
+		t.Fatal("the original should not be modified")
+	}
+}
@@ -0,0 +1,662 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// JsonPath supports a subset of JSONPath (https://goessner.net/articles/JsonPath/):
+//
+//	$.store.book[0].title      child names and array indices
+//	$['store']['book'][-1]     bracket notation, negative indices from the end
+//	$.store.*  $..author       wildcards and recursive descent
+//	$.book[1:3]  $.book[::2]   slices, [start:end:step]
+//	$.book[0,2]  $['a','b']    unions
+//	$..book[?(@.price < 10 && @.isbn)]
+//	                           filters comparing @ paths with literals by
+//	                           ==, !=, <, <=, >, >=, or testing existence
+//
+// Object members are visited in sorted key order.
+type JsonPath struct {
+	src   string
+	steps []jsonPathStep
+}
+
+const (
+	jpNames = iota
+	jpIndexes
+	jpSlice
+	jpWildcard
+	jpFilter
+)
+
+type jsonPathStep struct {
+	kind      int
+	recursive bool
+	names     []string
+	indexes   []int
+	slice     [3]*int
+	filter    jsonPathFilter
+}
+
+// filters are ORs of ANDs of comparisons
+type jsonPathFilter [][]jsonPathCond
+
+type jsonPathCond struct {
+	left, right jsonPathOperand
+	op          string // "" to test existence of left
+}
+
+type jsonPathOperand struct {
+	path  *JsonPath // relative to @
+	value interface{}
+}
+
+// JsonPathMatch is a matched value and its JSON pointer
+type JsonPathMatch struct {
+	Pointer string
+	Value   interface{}
+}
+
+func CompileJsonPath(path string) (*JsonPath, error) {
+	p := &jsonPathParser{src: path}
+	steps, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid JSONPath %q: %w", path, err)
+	}
+	return &JsonPath{src: path, steps: steps}, nil
+}
+
+func MustCompileJsonPath(path string) *JsonPath {
+	p, err := CompileJsonPath(path)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func (p *JsonPath) String() string {
+	return p.src
+}
+
+// JsonPathQuery compiles path and returns the matched values
+func JsonPathQuery(doc interface{}, path string) ([]interface{}, error) {
+	p, err := CompileJsonPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return p.Query(doc), nil
+}
+
+func (p *JsonPath) Query(doc interface{}) []interface{} {
+	matches := p.Find(doc)
+	ret := make([]interface{}, len(matches))
+	for i, m := range matches {
+		ret[i] = m.Value
+	}
+	return ret
+}
+
+// Find returns the matches with their pointers, in document order
+func (p *JsonPath) Find(doc interface{}) []JsonPathMatch {
+	nodes := p.eval(doc)
+	ret := make([]JsonPathMatch, len(nodes))
+	for i, n := range nodes {
+		ret[i] = JsonPathMatch{JsonPointer(n.tokens...), n.value}
+	}
+	return ret
+}
+
+type jsonPathNode struct {
+	tokens []string
+	value  interface{}
+}
+
+func (p *JsonPath) eval(doc interface{}) []jsonPathNode {
+	nodes := []jsonPathNode{{nil, doc}}
+	for _, step := range p.steps {
+		var next []jsonPathNode
+		for _, n := range nodes {
+			if step.recursive {
+				for _, d := range jsonDescendants(n, nil) {
+					next = step.apply(d, next)
+				}
+			} else {
+				next = step.apply(n, next)
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// jsonDescendants includes n, in pre-order
+func jsonDescendants(n jsonPathNode, ret []jsonPathNode) []jsonPathNode {
+	ret = append(ret, n)
+	keys, vals := jsonChildren(n.value)
+	for i, k := range keys {
+		ret = jsonDescendants(n.child(k, vals[i]), ret)
+	}
+	return ret
+}
+
+func (n jsonPathNode) child(key string, val interface{}) jsonPathNode {
+	tokens := make([]string, len(n.tokens)+1)
+	copy(tokens, n.tokens)
+	tokens[len(n.tokens)] = key
+	return jsonPathNode{tokens, val}
+}
+
+func (s *jsonPathStep) apply(n jsonPathNode, ret []jsonPathNode) []jsonPathNode {
+	switch s.kind {
+	case jpNames:
+		if jsonKind(n.value) != "object" {
+			return ret
+		}
+		for _, name := range s.names {
+			if child, ok := jsonChild(n.value, name); ok {
+				ret = append(ret, n.child(name, child))
+			}
+		}
+	case jpIndexes:
+		if jsonKind(n.value) != "array" {
+			return ret
+		}
+		keys, vals := jsonChildren(n.value)
+		for _, idx := range s.indexes {
+			if idx < 0 {
+				idx += len(vals)
+			}
+			if idx >= 0 && idx < len(vals) {
+				ret = append(ret, n.child(keys[idx], vals[idx]))
+			}
+		}
+	case jpSlice:
+		if jsonKind(n.value) != "array" {
+			return ret
+		}
+		keys, vals := jsonChildren(n.value)
+		for _, idx := range s.sliceIndexes(len(vals)) {
+			ret = append(ret, n.child(keys[idx], vals[idx]))
+		}
+	case jpWildcard:
+		keys, vals := jsonChildren(n.value)
+		for i, k := range keys {
+			ret = append(ret, n.child(k, vals[i]))
+		}
+	case jpFilter:
+		keys, vals := jsonChildren(n.value)
+		for i, k := range keys {
+			if s.filter.match(vals[i]) {
+				ret = append(ret, n.child(k, vals[i]))
+			}
+		}
+	}
+	return ret
+}
+
+func (s *jsonPathStep) sliceIndexes(length int) []int {
+	step := 1
+	if s.slice[2] != nil {
+		step = *s.slice[2]
+	}
+	if step == 0 {
+		return nil
+	}
+	bound := func(p *int, def int) int {
+		if p == nil {
+			return def
+		}
+		i := *p
+		if i < 0 {
+			i += length
+		}
+		if step > 0 {
+			return clampInt(i, 0, length)
+		}
+		return clampInt(i, -1, length-1)
+	}
+
+	var ret []int
+	if step > 0 {
+		for i := bound(s.slice[0], 0); i < bound(s.slice[1], length); i += step {
+			ret = append(ret, i)
+		}
+	} else {
+		for i := bound(s.slice[0], length-1); i > bound(s.slice[1], -1); i += step {
+			ret = append(ret, i)
+		}
+	}
+	return ret
+}
+
+func clampInt(i, min, max int) int {
+	if i < min {
+		return min
+	}
+	if i > max {
+		return max
+	}
+	return i
+}
+
+func (f jsonPathFilter) match(v interface{}) bool {
+	for _, and := range f {
+		ok := true
+		for _, cond := range and {
+			if !cond.match(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (o jsonPathOperand) resolve(v interface{}) (interface{}, bool) {
+	if o.path == nil {
+		return o.value, true
+	}
+	nodes := o.path.eval(v)
+	if len(nodes) == 0 {
+		return nil, false
+	}
+	return nodes[0].value, true
+}
+
+func (c jsonPathCond) match(v interface{}) bool {
+	left, ok := c.left.resolve(v)
+	if !ok {
+		return false
+	}
+	if c.op == "" {
+		return true
+	}
+	right, ok := c.right.resolve(v)
+	if !ok {
+		return false
+	}
+
+	if lf, ok := jsonNumber(left); ok {
+		if rf, ok := jsonNumber(right); ok {
+			switch c.op {
+			case "==":
+				return lf == rf
+			case "!=":
+				return lf != rf
+			case "<":
+				return lf < rf
+			case "<=":
+				return lf <= rf
+			case ">":
+				return lf > rf
+			case ">=":
+				return lf >= rf
+			}
+		}
+	}
+	if ls, ok := left.(string); ok {
+		if rs, ok := right.(string); ok {
+			switch c.op {
+			case "<":
+				return ls < rs
+			case "<=":
+				return ls <= rs
+			case ">":
+				return ls > rs
+			case ">=":
+				return ls >= rs
+			}
+		}
+	}
+	switch c.op {
+	case "==":
+		return reflect.DeepEqual(left, right)
+	case "!=":
+		return !reflect.DeepEqual(left, right)
+	}
+	return false
+}
+
+// jsonNumber converts Go numeric kinds to float64, as decoded by encoding/json
+func jsonNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+// parsing
+
+type jsonPathParser struct {
+	src string
+	pos int
+}
+
+func (p *jsonPathParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("at %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *jsonPathParser) peek(s string) bool {
+	return strings.HasPrefix(p.src[p.pos:], s)
+}
+
+func (p *jsonPathParser) skipSpaces() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *jsonPathParser) parse() ([]jsonPathStep, error) {
+	if p.peek("$") || p.peek("@") {
+		p.pos++
+	}
+	var steps []jsonPathStep
+	for p.pos < len(p.src) {
+		recursive := false
+		switch {
+		case p.peek(".."):
+			p.pos += 2
+			recursive = true
+		case p.peek("."):
+			p.pos++
+		case p.peek("["):
+		default:
+			return nil, p.errorf("unexpected %q", p.src[p.pos])
+		}
+
+		var step jsonPathStep
+		var err error
+		switch {
+		case p.peek("["):
+			step, err = p.bracket()
+		case p.peek("*"):
+			p.pos++
+			step = jsonPathStep{kind: jpWildcard}
+		default:
+			name := p.name()
+			if name == "" {
+				return nil, p.errorf("missing name")
+			}
+			step = jsonPathStep{kind: jpNames, names: []string{name}}
+		}
+		if err != nil {
+			return nil, err
+		}
+		step.recursive = recursive
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+func (p *jsonPathParser) name() string {
+	start := p.pos
+	for p.pos < len(p.src) && !strings.ContainsRune(".[ \t=!<>&|)", rune(p.src[p.pos])) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *jsonPathParser) bracket() (jsonPathStep, error) {
+	p.pos++ // [
+	p.skipSpaces()
+	var step jsonPathStep
+
+	switch {
+	case p.peek("*"):
+		p.pos++
+		step.kind = jpWildcard
+	case p.peek("?"):
+		p.pos++
+		p.skipSpaces()
+		paren := p.peek("(")
+		if paren {
+			p.pos++
+		}
+		filter, err := p.filter()
+		if err != nil {
+			return step, err
+		}
+		if paren {
+			p.skipSpaces()
+			if !p.peek(")") {
+				return step, p.errorf("missing )")
+			}
+			p.pos++
+		}
+		step.kind, step.filter = jpFilter, filter
+	case p.peek("'") || p.peek(`"`):
+		step.kind = jpNames
+		for {
+			s, err := p.quoted()
+			if err != nil {
+				return step, err
+			}
+			step.names = append(step.names, s)
+			if !p.comma() {
+				break
+			}
+		}
+	default:
+		if err := p.indexes(&step); err != nil {
+			return step, err
+		}
+	}
+
+	p.skipSpaces()
+	if !p.peek("]") {
+		return step, p.errorf("missing ]")
+	}
+	p.pos++
+	return step, nil
+}
+
+func (p *jsonPathParser) comma() bool {
+	p.skipSpaces()
+	if p.peek(",") {
+		p.pos++
+		p.skipSpaces()
+		return true
+	}
+	return false
+}
+
+func (p *jsonPathParser) quoted() (string, error) {
+	q := p.src[p.pos]
+	var sb strings.Builder
+	for i := p.pos + 1; i < len(p.src); i++ {
+		switch ch := p.src[i]; {
+		case ch == '\\' && i+1 < len(p.src):
+			i++
+			sb.WriteByte(p.src[i])
+		case ch == q:
+			p.pos = i + 1
+			return sb.String(), nil
+		default:
+			sb.WriteByte(ch)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *jsonPathParser) int() (*int, error) {
+	p.skipSpaces()
+	start := p.pos
+	if p.peek("-") {
+		p.pos++
+	}
+	for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+		p.pos++
+	}
+	if start == p.pos {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(p.src[start:p.pos])
+	if err != nil {
+		return nil, p.errorf("bad index %q", p.src[start:p.pos])
+	}
+	p.skipSpaces()
+	return &n, nil
+}
+
+func (p *jsonPathParser) indexes(step *jsonPathStep) error {
+	first, err := p.int()
+	if err != nil {
+		return err
+	}
+	if p.peek(":") {
+		step.kind = jpSlice
+		step.slice[0] = first
+		for i := 1; i < 3 && p.peek(":"); i++ {
+			p.pos++
+			if step.slice[i], err = p.int(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	step.kind = jpIndexes
+	for idx := first; ; {
+		if idx == nil {
+			return p.errorf("missing index")
+		}
+		step.indexes = append(step.indexes, *idx)
+		if !p.comma() {
+			return nil
+		}
+		if idx, err = p.int(); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *jsonPathParser) filter() (jsonPathFilter, error) {
+	var f jsonPathFilter
+	var and []jsonPathCond
+	for {
+		cond, err := p.cond()
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, cond)
+		p.skipSpaces()
+		switch {
+		case p.peek("&&"):
+			p.pos += 2
+		case p.peek("||"):
+			p.pos += 2
+			f = append(f, and)
+			and = nil
+		default:
+			return append(f, and), nil
+		}
+	}
+}
+
+func (p *jsonPathParser) cond() (jsonPathCond, error) {
+	var c jsonPathCond
+	var err error
+	if c.left, err = p.operand(); err != nil {
+		return c, err
+	}
+	p.skipSpaces()
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.peek(op) {
+			p.pos += len(op)
+			c.op = op
+			c.right, err = p.operand()
+			return c, err
+		}
+	}
+	if c.left.path == nil {
+		return c, p.errorf("filter needs an @ path")
+	}
+	return c, nil
+}
+
+func (p *jsonPathParser) operand() (jsonPathOperand, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.src) {
+		return jsonPathOperand{}, p.errorf("missing operand")
+	}
+
+	switch ch := p.src[p.pos]; {
+	case ch == '@':
+		start := p.pos
+		p.pos++
+		depth := 0
+		for p.pos < len(p.src) {
+			c := p.src[p.pos]
+			if c == '\'' || c == '"' {
+				if _, err := p.quoted(); err != nil {
+					return jsonPathOperand{}, err
+				}
+				continue
+			}
+			if c == '[' {
+				depth++
+			} else if c == ']' {
+				if depth == 0 {
+					break
+				}
+				depth--
+			} else if depth == 0 && strings.ContainsRune(" \t=!<>&|)", rune(c)) {
+				break
+			}
+			p.pos++
+		}
+		sub := &jsonPathParser{src: p.src[start:p.pos]}
+		steps, err := sub.parse()
+		if err != nil {
+			return jsonPathOperand{}, err
+		}
+		return jsonPathOperand{path: &JsonPath{src: sub.src, steps: steps}}, nil
+	case ch == '\'' || ch == '"':
+		s, err := p.quoted()
+		return jsonPathOperand{value: s}, err
+	}
+
+	for _, lit := range []struct {
+		s string
+		v interface{}
+	}{{"true", true}, {"false", false}, {"null", nil}} {
+		if p.peek(lit.s) {
+			p.pos += len(lit.s)
+			return jsonPathOperand{value: lit.v}, nil
+		}
+	}
+
+	start := p.pos
+	for p.pos < len(p.src) && strings.ContainsRune("+-.0123456789eE", rune(p.src[p.pos])) {
+		p.pos++
+	}
+	f, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+	if err != nil {
+		return jsonPathOperand{}, p.errorf("bad literal %q", p.src[start:p.pos])
+	}
+	return jsonPathOperand{value: f}, nil
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// from https://goessner.net/articles/JsonPath/
+const jsonPathStore = `{"store": {
+	"book": [
+		{"category": "reference", "author": "Nigel Rees", "title": "Sayings of the Century", "price": 8.95},
+		{"category": "fiction", "author": "Evelyn Waugh", "title": "Sword of Honour", "price": 12.99},
+		{"category": "fiction", "author": "Herman Melville", "title": "Moby Dick", "isbn": "0-553-21311-3", "price": 8.99},
+		{"category": "fiction", "author": "J. R. R. Tolkien", "title": "The Lord of the Rings", "isbn": "0-395-19395-8", "price": 22.99}
+	],
+	"bicycle": {"color": "red", "price": 19.95}
+}}`
+
+func TestJsonPath(t *testing.T) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(jsonPathStore), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		path string
+		want []interface{}
+	}{
+		{"$.store.book[*].author", []interface{}{"Nigel Rees", "Evelyn Waugh", "Herman Melville", "J. R. R. Tolkien"}},
+		{"$..author", []interface{}{"Nigel Rees", "Evelyn Waugh", "Herman Melville", "J. R. R. Tolkien"}},
+		{"$.store.*.color", []interface{}{"red"}},
+		{"$.store..price", []interface{}{19.95, 8.95, 12.99, 8.99, 22.99}},
+		{"$..book[2].title", []interface{}{"Moby Dick"}},
+		{"$..book[-1].title", []interface{}{"The Lord of the Rings"}},
+		{"$..book[0,1].price", []interface{}{8.95, 12.99}},
+		{"$..book[:2].price", []interface{}{8.95, 12.99}},
+		{"$..book[1:].price", []interface{}{12.99, 8.99, 22.99}},
+		{"$..book[::-2].price", []interface{}{22.99, 12.99}},
+		{"$['store']['bicycle']['color', 'price']", []interface{}{"red", 19.95}},
+		{"$..book[?(@.isbn)].title", []interface{}{"Moby Dick", "The Lord of the Rings"}},
+		{"$..book[?(@.price < 10)].title", []interface{}{"Sayings of the Century", "Moby Dick"}},
+		{"$..book[?(@.category == 'fiction' && @.price <= 12.99)].title", []interface{}{"Sword of Honour", "Moby Dick"}},
+		{"$..book[?(@.price > 20 || @.author == \"Nigel Rees\")].price", []interface{}{8.95, 22.99}},
+		{"$.store.book[5]", []interface{}{}},
+	}
+	for _, tt := range tests {
+		got, err := JsonPathQuery(doc, tt.path)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.path, err)
+		}
+		if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+			t.Fatalf("%s: expected %v, got %v", tt.path, tt.want, got)
+		}
+	}
+
+	matches := MustCompileJsonPath("$..book[?(@.price > 20)].title").Find(doc)
+	if len(matches) != 1 || matches[0].Pointer != "/store/book/3/title" {
+		t.Fatalf("bad matches %+v", matches)
+	}
+
+	// Go values
+	m := Map{"users": []Map{{"name": "a", "age": 30}, {"name": "b", "age": 20}}}
+	if got, _ := JsonPathQuery(m, "$.users[?(@.age >= 25)].name"); !reflect.DeepEqual(got, []interface{}{"a"}) {
+		t.Fatalf("bad Go value query %v", got)
+	}
+
+	for _, bad := range []string{"$.", "$[", "$[1", "$['a]", "$[?(@.a <)]", "$x"} {
+		if _, err := CompileJsonPath(bad); err == nil {
+			t.Fatalf("%s should not compile", bad)
+		}
+	}
+}
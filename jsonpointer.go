@@ -0,0 +1,332 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// REF: https://www.rfc-editor.org/rfc/rfc6901
+// documents are Map, map[string]interface{} and []interface{} as decoded by
+// encoding/json; Get also reads other string-keyed maps and slices
+
+var (
+	ErrJsonPointerSyntax   = errors.New("Invalid JSON pointer")
+	ErrJsonPointerNotFound = errors.New("JSON pointer not found")
+)
+
+// ParseJsonPointer returns the unescaped reference tokens, accepting the
+// URI fragment form like "#/a%20b" too
+func ParseJsonPointer(ptr string) ([]string, error) {
+	if strings.HasPrefix(ptr, "#") {
+		frag, err := neturl.PathUnescape(ptr[1:])
+		if err != nil {
+			return nil, fmt.Errorf("%w %q", ErrJsonPointerSyntax, ptr)
+		}
+		ptr = frag
+	}
+	if ptr == "" {
+		return nil, nil
+	}
+	if ptr[0] != '/' {
+		return nil, fmt.Errorf("%w %q", ErrJsonPointerSyntax, ptr)
+	}
+	tokens := strings.Split(ptr[1:], "/")
+	for i, tok := range tokens {
+		if strings.Contains(tok, "~") {
+			for j := 0; j < len(tok); j++ {
+				if tok[j] == '~' && (j+1 == len(tok) || (tok[j+1] != '0' && tok[j+1] != '1')) {
+					return nil, fmt.Errorf("%w %q", ErrJsonPointerSyntax, ptr)
+				}
+			}
+			tokens[i] = strings.ReplaceAll(strings.ReplaceAll(tok, "~1", "/"), "~0", "~")
+		}
+	}
+	return tokens, nil
+}
+
+// JsonPointer escapes tokens into a pointer, e.g. "/a~1b/0" for "a/b", "0"
+func JsonPointer(tokens ...string) string {
+	var sb strings.Builder
+	for _, tok := range tokens {
+		sb.WriteByte('/')
+		sb.WriteString(strings.ReplaceAll(strings.ReplaceAll(tok, "~", "~0"), "/", "~1"))
+	}
+	return sb.String()
+}
+
+func JsonPointerGet(doc interface{}, ptr string) (interface{}, error) {
+	tokens, err := ParseJsonPointer(ptr)
+	if err != nil {
+		return nil, err
+	}
+	cur := doc
+	for i, tok := range tokens {
+		child, ok := jsonChild(cur, tok)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrJsonPointerNotFound, JsonPointer(tokens[:i+1]...))
+		}
+		cur = child
+	}
+	return cur, nil
+}
+
+// JsonPointerSet replaces or adds the value, appending to arrays for index
+// "-" or the array length, and returns the new document, which is val for
+// the root pointer ""; maps are modified in place
+func JsonPointerSet(doc interface{}, ptr string, val interface{}) (interface{}, error) {
+	tokens, err := ParseJsonPointer(ptr)
+	if err != nil || len(tokens) == 0 {
+		return val, err
+	}
+	return jsonUpdatePath(doc, tokens, tokens, func(parent interface{}, path []string) (interface{}, error) {
+		return jsonSetChild(parent, path, val, true)
+	})
+}
+
+// JsonPointerDelete removes the value, shifting array elements, and returns
+// the new document, nil for the root pointer
+func JsonPointerDelete(doc interface{}, ptr string) (interface{}, error) {
+	tokens, err := ParseJsonPointer(ptr)
+	if err != nil || len(tokens) == 0 {
+		return nil, err
+	}
+	return jsonUpdatePath(doc, tokens, tokens, jsonRemoveChild)
+}
+
+// jsonUpdatePath calls fn with the parent of the target and the full path of
+// the target, returning cur with the parent replaced by the result of fn
+func jsonUpdatePath(cur interface{}, tokens, all []string, fn func(interface{}, []string) (interface{}, error)) (interface{}, error) {
+	path := all[:len(all)-len(tokens)+1]
+	if len(tokens) == 1 {
+		return fn(cur, path)
+	}
+	child, ok := jsonChild(cur, tokens[0])
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrJsonPointerNotFound, JsonPointer(path...))
+	}
+	newChild, err := jsonUpdatePath(child, tokens[1:], all, fn)
+	if err != nil {
+		return nil, err
+	}
+	return jsonSetChild(cur, path, newChild, false)
+}
+
+// jsonIndex parses array indexes per RFC 6901, without leading zeros
+func jsonIndex(tok string, length int, allowEnd bool) (int, bool) {
+	if allowEnd && tok == "-" {
+		return length, true
+	}
+	if tok == "" || (len(tok) > 1 && tok[0] == '0') {
+		return 0, false
+	}
+	for _, ch := range tok {
+		if ch < '0' || ch > '9' {
+			return 0, false
+		}
+	}
+	idx, err := strconv.Atoi(tok)
+	if err != nil || idx > length || (idx == length && !allowEnd) {
+		return 0, false
+	}
+	return idx, true
+}
+
+func jsonChild(v interface{}, tok string) (interface{}, bool) {
+	switch c := v.(type) {
+	case Map:
+		child, ok := c[tok]
+		return child, ok
+	case map[string]interface{}:
+		child, ok := c[tok]
+		return child, ok
+	case []interface{}:
+		idx, ok := jsonIndex(tok, len(c), false)
+		if !ok {
+			return nil, false
+		}
+		return c[idx], true
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		child := rv.MapIndex(reflect.ValueOf(tok).Convert(rv.Type().Key()))
+		if !child.IsValid() {
+			return nil, false
+		}
+		return child.Interface(), true
+	case reflect.Slice, reflect.Array:
+		idx, ok := jsonIndex(tok, rv.Len(), false)
+		if !ok {
+			return nil, false
+		}
+		return rv.Index(idx).Interface(), true
+	}
+	return nil, false
+}
+
+// jsonSetChild sets the last token of path or, if add, appends array elements;
+// path is the full path for errors
+func jsonSetChild(v interface{}, path []string, val interface{}, add bool) (interface{}, error) {
+	tok := path[len(path)-1]
+	switch c := v.(type) {
+	case Map:
+		if _, ok := c[tok]; !ok && !add {
+			return nil, fmt.Errorf("%w: %s", ErrJsonPointerNotFound, JsonPointer(path...))
+		}
+		c[tok] = val
+		return c, nil
+	case map[string]interface{}:
+		if _, ok := c[tok]; !ok && !add {
+			return nil, fmt.Errorf("%w: %s", ErrJsonPointerNotFound, JsonPointer(path...))
+		}
+		c[tok] = val
+		return c, nil
+	case []interface{}:
+		idx, ok := jsonIndex(tok, len(c), add)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrJsonPointerNotFound, JsonPointer(path...))
+		}
+		if idx == len(c) {
+			return append(c, val), nil
+		}
+		c[idx] = val
+		return c, nil
+	}
+	return nil, fmt.Errorf("Cannot set %s in %T", JsonPointer(path...), v)
+}
+
+// jsonInsertChild inserts into arrays, shifting elements, or sets map values
+func jsonInsertChild(v interface{}, path []string, val interface{}) (interface{}, error) {
+	c, ok := v.([]interface{})
+	if !ok {
+		return jsonSetChild(v, path, val, true)
+	}
+	tok := path[len(path)-1]
+	idx, ok := jsonIndex(tok, len(c), true)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrJsonPointerNotFound, JsonPointer(path...))
+	}
+	c = append(c, nil)
+	copy(c[idx+1:], c[idx:])
+	c[idx] = val
+	return c, nil
+}
+
+func jsonRemoveChild(v interface{}, path []string) (interface{}, error) {
+	tok := path[len(path)-1]
+	switch c := v.(type) {
+	case Map:
+		if _, ok := c[tok]; ok {
+			delete(c, tok)
+			return c, nil
+		}
+		return nil, fmt.Errorf("%w: %s", ErrJsonPointerNotFound, JsonPointer(path...))
+	case map[string]interface{}:
+		if _, ok := c[tok]; ok {
+			delete(c, tok)
+			return c, nil
+		}
+		return nil, fmt.Errorf("%w: %s", ErrJsonPointerNotFound, JsonPointer(path...))
+	case []interface{}:
+		idx, ok := jsonIndex(tok, len(c), false)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrJsonPointerNotFound, JsonPointer(path...))
+		}
+		return append(c[:idx:idx], c[idx+1:]...), nil
+	}
+	return nil, fmt.Errorf("Cannot remove %s from %T", JsonPointer(path...), v)
+}
+
+// jsonChildren lists keys, sorted for maps, and values of containers
+func jsonChildren(v interface{}) ([]string, []interface{}) {
+	switch c := v.(type) {
+	case Map:
+		return jsonMapChildren(c)
+	case map[string]interface{}:
+		return jsonMapChildren(c)
+	case []interface{}:
+		keys := make([]string, len(c))
+		for i := range c {
+			keys[i] = strconv.Itoa(i)
+		}
+		return keys, c
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, nil
+		}
+		keys := make([]string, 0, rv.Len())
+		for _, k := range rv.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+		vals := make([]interface{}, len(keys))
+		for i, k := range keys {
+			vals[i] = rv.MapIndex(reflect.ValueOf(k).Convert(rv.Type().Key())).Interface()
+		}
+		return keys, vals
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 { // []byte as in json
+			return nil, nil
+		}
+		keys := make([]string, rv.Len())
+		vals := make([]interface{}, rv.Len())
+		for i := range keys {
+			keys[i] = strconv.Itoa(i)
+			vals[i] = rv.Index(i).Interface()
+		}
+		return keys, vals
+	}
+	return nil, nil
+}
+
+func jsonMapChildren(m map[string]interface{}) ([]string, []interface{}) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	vals := make([]interface{}, len(keys))
+	for i, k := range keys {
+		vals[i] = m[k]
+	}
+	return keys, vals
+}
+
+// jsonKind is "object", "array" or "" for other values
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case Map, map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case nil, string, bool, float64, []byte:
+		return ""
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() == reflect.String {
+			return "object"
+		}
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() != reflect.Uint8 {
+			return "array"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// from RFC 6901
+const rfc6901Doc = `{
+	"foo": ["bar", "baz"],
+	"": 0,
+	"a/b": 1,
+	"c%d": 2,
+	"e^f": 3,
+	"g|h": 4,
+	"i\\j": 5,
+	"k\"l": 6,
+	" ": 7,
+	"m~n": 8
+}`
+
+func TestJsonPointerGet(t *testing.T) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(rfc6901Doc), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		ptr  string
+		want interface{}
+	}{
+		{"/foo", []interface{}{"bar", "baz"}},
+		{"/foo/0", "bar"},
+		{"/", 0.0},
+		{"/a~1b", 1.0},
+		{"/c%d", 2.0},
+		{"/i\\j", 5.0},
+		{"/ ", 7.0},
+		{"/m~0n", 8.0},
+		{"#/c%25d", 2.0},
+		{"#/foo/1", "baz"},
+	}
+	for _, tt := range tests {
+		got, err := JsonPointerGet(doc, tt.ptr)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: expected %v, got %v %v", tt.ptr, tt.want, got, err)
+		}
+	}
+	if got, _ := JsonPointerGet(doc, ""); !reflect.DeepEqual(got, doc) {
+		t.Fatal("empty pointer should get the whole document")
+	}
+
+	for _, ptr := range []string{"/foo/2", "/foo/01", "/foo/-", "/x", "/foo/0/x"} {
+		if _, err := JsonPointerGet(doc, ptr); !errors.Is(err, ErrJsonPointerNotFound) {
+			t.Fatalf("%s: expected not found, got %v", ptr, err)
+		}
+	}
+	for _, ptr := range []string{"foo", "/m~2n", "/m~"} {
+		if _, err := JsonPointerGet(doc, ptr); !errors.Is(err, ErrJsonPointerSyntax) {
+			t.Fatalf("%s: expected syntax error, got %v", ptr, err)
+		}
+	}
+
+	// Go values
+	m := Map{"list": []Map{{"n": 1}}, "strs": StrMap{"a": "b"}}
+	if got, err := JsonPointerGet(m, "/list/0/n"); err != nil || got != 1 {
+		t.Fatalf("bad Go value %v %v", got, err)
+	}
+	if got, err := JsonPointerGet(m, "/strs/a"); err != nil || got != "b" {
+		t.Fatalf("bad StrMap value %v %v", got, err)
+	}
+}
+
+func TestJsonPointerSetDelete(t *testing.T) {
+	doc := Map{"a": []interface{}{1, 2}, "b": map[string]interface{}{"c": 3}}
+
+	var err error
+	steps := []struct {
+		set  bool
+		ptr  string
+		val  interface{}
+		want string
+	}{
+		{true, "/a/-", 3, `{"a":[1,2,3],"b":{"c":3}}`},
+		{true, "/a/3", 4, `{"a":[1,2,3,4],"b":{"c":3}}`},
+		{true, "/a/0", 0, `{"a":[0,2,3,4],"b":{"c":3}}`},
+		{true, "/b/d", "x", `{"a":[0,2,3,4],"b":{"c":3,"d":"x"}}`},
+		{false, "/a/1", nil, `{"a":[0,3,4],"b":{"c":3,"d":"x"}}`},
+		{false, "/b/c", nil, `{"a":[0,3,4],"b":{"d":"x"}}`},
+		{false, "/b", nil, `{"a":[0,3,4]}`},
+	}
+	var cur interface{} = doc
+	for _, st := range steps {
+		if st.set {
+			cur, err = JsonPointerSet(cur, st.ptr, st.val)
+		} else {
+			cur, err = JsonPointerDelete(cur, st.ptr)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", st.ptr, err)
+		}
+		if buf, _ := json.Marshal(cur); !JsonStrEqual(string(buf), st.want) {
+			t.Fatalf("%s: expected %s, got %s", st.ptr, st.want, buf)
+		}
+	}
+
+	// errors report the full path
+	nested := Map{"a": Map{"b": []interface{}{1.0}, "s": "x"}}
+	var errTests = []struct {
+		err  error
+		want string
+	}{
+		{func() error { _, err := JsonPointerSet(nested, "/a/b/5", 1); return err }(), "/a/b/5"},
+		{func() error { _, err := JsonPointerSet(nested, "/a/s/c", 1); return err }(), "Cannot set /a/s/c"},
+		{func() error { _, err := JsonPointerDelete(nested, "/a/b/1"); return err }(), "/a/b/1"},
+		{func() error { _, err := JsonPointerDelete(nested, "/a/c"); return err }(), "/a/c"},
+		{func() error { _, err := JsonPointerDelete(nested, "/x/y"); return err }(), "/x"},
+		{func() error {
+			_, err := JsonPatch{{Op: "replace", Path: "/a/missing", Value: 1}}.ApplyMap(nested)
+			return err
+		}(), "/a/missing"},
+		{func() error { _, err := JsonPatch{{Op: "add", Path: "/a/b/3", Value: 1}}.ApplyMap(nested); return err }(), "/a/b/3"},
+	}
+	for _, tt := range errTests {
+		if tt.err == nil || !strings.Contains(tt.err.Error(), tt.want) {
+			t.Fatalf("expected %s, got %v", tt.want, tt.err)
+		}
+	}
+	if root, _ := JsonPointerSet(cur, "", "new"); root != "new" {
+		t.Fatal("setting the root should replace the document")
+	}
+}
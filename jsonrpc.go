@@ -0,0 +1,468 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// REF: https://www.jsonrpc.org/specification
+
+const (
+	RpcParseError     = -32700
+	RpcInvalidRequest = -32600
+	RpcMethodNotFound = -32601
+	RpcInvalidParams  = -32602
+	RpcInternalError  = -32603
+	RpcServerError    = -32000 // -32000 to -32099 reserved for server errors
+)
+
+type RpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *RpcError) Error() string {
+	return fmt.Sprintf("RPC error %d: %s", e.Code, e.Message)
+}
+
+// RpcErrorFrom maps errors returned by methods; a goutil.Error is kept in
+// Data so that clients can recover it with ToError
+func RpcErrorFrom(err error) *RpcError {
+	var re *RpcError
+	if errors.As(err, &re) {
+		return re
+	}
+	var e Error
+	if errors.As(err, &e) {
+		code := RpcServerError
+		switch e.Code {
+		case BadRequest:
+			code = RpcInvalidParams
+		case InternalServerError:
+			code = RpcInternalError
+		}
+		return &RpcError{Code: code, Message: e.Message, Data: e}
+	}
+	return &RpcError{Code: RpcInternalError, Message: err.Error()}
+}
+
+// ToError converts back to goutil.Error, from Data if possible
+func (e *RpcError) ToError() Error {
+	if e.Data != nil {
+		if buf, err := json.Marshal(e.Data); err == nil {
+			var ret Error
+			if json.Unmarshal(buf, &ret) == nil && ret.Code > 0 {
+				return ret
+			}
+		}
+	}
+	code := InternalServerError
+	switch e.Code {
+	case RpcParseError, RpcInvalidRequest, RpcInvalidParams:
+		code = BadRequest
+	case RpcMethodNotFound:
+		code = NotFound
+	}
+	return StdError(code, e.Message)
+}
+
+type rpcResponse struct {
+	Jsonrpc string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+var rpcNullID = json.RawMessage("null")
+
+// server
+
+var (
+	rpcCtxType   = reflect.TypeOf((*context.Context)(nil)).Elem()
+	rpcErrorType = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+type rpcMethod struct {
+	fn      reflect.Value
+	withCtx bool
+	params  []reflect.Type
+	result  bool
+}
+
+type RpcServer struct {
+	mu      sync.RWMutex
+	methods map[string]*rpcMethod
+}
+
+func NewRpcServer() *RpcServer {
+	return &RpcServer{methods: map[string]*rpcMethod{}}
+}
+
+// Register adds a method implemented by fn of the form
+//
+//	func([ctx context.Context,] [params ...]) ([result,] error)
+//
+// Named params (a json object) are decoded into a single struct or map
+// parameter; positional params (a json array) into the parameters in order,
+// or into a single slice parameter.
+func (s *RpcServer) Register(name string, fn interface{}) error {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if t.Kind() != reflect.Func {
+		return fmt.Errorf("%s: not a function", name)
+	}
+
+	m := &rpcMethod{fn: v}
+	for i := 0; i < t.NumIn(); i++ {
+		if i == 0 && t.In(i) == rpcCtxType {
+			m.withCtx = true
+			continue
+		}
+		m.params = append(m.params, t.In(i))
+	}
+
+	switch t.NumOut() {
+	case 2:
+		m.result = true
+		fallthrough
+	case 1:
+		if t.Out(t.NumOut()-1) != rpcErrorType {
+			return fmt.Errorf("%s: last result must be error", name)
+		}
+	default:
+		return fmt.Errorf("%s: must return ([result,] error)", name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.methods[name] = m
+	return nil
+}
+
+func (s *RpcServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		WriteJsonError(w, http.StatusMethodNotAllowed, "JSON-RPC requires POST")
+		return
+	}
+	body, err := ReadRequestBody(req)
+	if err != nil {
+		WriteJsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ret := s.Handle(req.Context(), body)
+	if ret == nil { // notifications only
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", ctAppJson)
+	w.Write(ret)
+}
+
+// Handle processes a single or batch request, returning nil if there is
+// nothing to respond, e.g. for use over other transports
+func (s *RpcServer) Handle(ctx context.Context, body []byte) []byte {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return rpcMarshal(rpcErrorResponse(rpcNullID, RpcParseError, "Parse error"))
+		}
+		if len(batch) == 0 {
+			return rpcMarshal(rpcErrorResponse(rpcNullID, RpcInvalidRequest, "Invalid Request"))
+		}
+
+		results := make([]*rpcResponse, len(batch))
+		var wg sync.WaitGroup
+		for i := range batch {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i] = s.handleOne(ctx, batch[i])
+			}(i)
+		}
+		wg.Wait()
+
+		var ret []*rpcResponse
+		for _, res := range results {
+			if res != nil {
+				ret = append(ret, res)
+			}
+		}
+		if len(ret) == 0 {
+			return nil
+		}
+		return rpcMarshal(ret)
+	}
+
+	if !json.Valid(body) {
+		return rpcMarshal(rpcErrorResponse(rpcNullID, RpcParseError, "Parse error"))
+	}
+	if res := s.handleOne(ctx, body); res != nil {
+		return rpcMarshal(res)
+	}
+	return nil
+}
+
+func rpcMarshal(v interface{}) []byte {
+	buf, _ := json.Marshal(v)
+	return buf
+}
+
+func rpcErrorResponse(id json.RawMessage, code int, msg string) *rpcResponse {
+	return &rpcResponse{Jsonrpc: "2.0", Error: &RpcError{Code: code, Message: msg}, ID: id}
+}
+
+func (s *RpcServer) handleOne(ctx context.Context, raw json.RawMessage) *rpcResponse {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return rpcErrorResponse(rpcNullID, RpcInvalidRequest, "Invalid Request")
+	}
+
+	id, hasID := fields["id"]
+	if !hasID {
+		id = rpcNullID
+	}
+	var version, name string
+	if json.Unmarshal(fields["jsonrpc"], &version) != nil || version != "2.0" ||
+		json.Unmarshal(fields["method"], &name) != nil || name == "" {
+		return rpcErrorResponse(id, RpcInvalidRequest, "Invalid Request")
+	}
+
+	result, rerr := s.call(ctx, name, fields["params"])
+	if !hasID {
+		return nil
+	}
+	if rerr != nil {
+		return &rpcResponse{Jsonrpc: "2.0", Error: rerr, ID: id}
+	}
+	return &rpcResponse{Jsonrpc: "2.0", Result: result, ID: id}
+}
+
+func (s *RpcServer) call(ctx context.Context, name string, params json.RawMessage) (ret json.RawMessage, rerr *RpcError) {
+	s.mu.RLock()
+	m, ok := s.methods[name]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, &RpcError{Code: RpcMethodNotFound, Message: "Method not found"}
+	}
+
+	args, err := m.decodeParams(params)
+	if err != nil {
+		return nil, &RpcError{Code: RpcInvalidParams, Message: "Invalid params", Data: err.Error()}
+	}
+	if m.withCtx {
+		args = append([]reflect.Value{reflect.ValueOf(ctx)}, args...)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			ret, rerr = nil, &RpcError{Code: RpcInternalError, Message: fmt.Sprint(r)}
+		}
+	}()
+	outs := m.fn.Call(args)
+	if errv := outs[len(outs)-1]; !errv.IsNil() {
+		return nil, RpcErrorFrom(errv.Interface().(error))
+	}
+	if !m.result {
+		return json.RawMessage("null"), nil
+	}
+	buf, err := json.Marshal(outs[0].Interface())
+	if err != nil {
+		return nil, &RpcError{Code: RpcInternalError, Message: err.Error()}
+	}
+	return buf, nil
+}
+
+func (m *rpcMethod) decodeParams(params json.RawMessage) ([]reflect.Value, error) {
+	params = bytes.TrimSpace(params)
+	args := make([]reflect.Value, len(m.params))
+	for i, t := range m.params {
+		args[i] = reflect.New(t)
+	}
+
+	switch {
+	case len(params) == 0 || bytes.Equal(params, rpcNullID):
+		// all zero values
+	case params[0] == '{':
+		if len(m.params) != 1 {
+			return nil, fmt.Errorf("Named params need a single parameter")
+		}
+		if err := json.Unmarshal(params, args[0].Interface()); err != nil {
+			return nil, err
+		}
+	case params[0] == '[':
+		if len(m.params) == 1 && m.params[0].Kind() == reflect.Slice {
+			if err := json.Unmarshal(params, args[0].Interface()); err != nil {
+				return nil, err
+			}
+			break
+		}
+		var items []json.RawMessage
+		if err := json.Unmarshal(params, &items); err != nil {
+			return nil, err
+		}
+		if len(items) > len(m.params) {
+			return nil, fmt.Errorf("Too many params: %d > %d", len(items), len(m.params))
+		}
+		for i, item := range items {
+			if err := json.Unmarshal(item, args[i].Interface()); err != nil {
+				return nil, fmt.Errorf("Param %d: %v", i, err)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("Params must be an array or object")
+	}
+
+	for i := range args {
+		args[i] = args[i].Elem()
+	}
+	return args, nil
+}
+
+// client
+
+type RpcClient struct {
+	URL     string
+	Headers StrMap
+	nextID  int64
+}
+
+func NewRpcClient(url string, headers StrMap) *RpcClient {
+	return &RpcClient{URL: url, Headers: headers}
+}
+
+type rpcRequest struct {
+	Jsonrpc string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+	ID      *int64      `json:"id,omitempty"`
+}
+
+func (c *RpcClient) newID() *int64 {
+	id := atomic.AddInt64(&c.nextID, 1)
+	return &id
+}
+
+// Call invokes method, unmarshaling the result into ret if not nil;
+// failures from the server are returned as *RpcError, or as goutil.Error
+// for non-2xx HTTP responses
+func (c *RpcClient) Call(method string, params interface{}, ret interface{}) error {
+	call := &RpcCall{Method: method, Params: params, Result: ret}
+	if err := c.Batch(call); err != nil {
+		return err
+	}
+	if call.Error != nil {
+		return call.Error
+	}
+	return nil
+}
+
+func (c *RpcClient) Notify(method string, params interface{}) error {
+	body, err := json.Marshal(rpcRequest{Jsonrpc: "2.0", Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	_, err = c.post(body)
+	return err
+}
+
+// post returns the response body, or ResponseError for non-2xx statuses,
+// e.g. for 405 or 500 with a JsonMsg error not in a JSON-RPC envelope
+func (c *RpcClient) post(body []byte) ([]byte, error) {
+	res, err := HttpDo(http.MethodPost, c.URL, ctAppJson, body, c.Headers)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, ResponseError(res)
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+type RpcCall struct {
+	Method string
+	Params interface{}
+	Result interface{} // to unmarshal into, if not nil
+	Error  *RpcError
+}
+
+// Batch sends calls in one request; errors of individual calls are set in
+// their Error fields, while HTTP errors are returned as goutil.Error
+func (c *RpcClient) Batch(calls ...*RpcCall) error {
+	if len(calls) == 0 {
+		return nil
+	}
+	reqs := make([]rpcRequest, len(calls))
+	byID := map[int64]*RpcCall{}
+	for i, call := range calls {
+		id := c.newID()
+		reqs[i] = rpcRequest{Jsonrpc: "2.0", Method: call.Method, Params: call.Params, ID: id}
+		byID[*id] = call
+	}
+
+	var body []byte
+	var err error
+	if len(reqs) == 1 {
+		body, err = json.Marshal(reqs[0])
+	} else {
+		body, err = json.Marshal(reqs)
+	}
+	if err != nil {
+		return err
+	}
+
+	buf, err := c.post(body)
+	if err != nil {
+		return err
+	}
+
+	var responses []rpcResponse
+	buf = bytes.TrimSpace(buf)
+	if len(buf) > 0 && buf[0] == '[' {
+		err = json.Unmarshal(buf, &responses)
+	} else {
+		var res rpcResponse
+		err = json.Unmarshal(buf, &res)
+		responses = append(responses, res)
+	}
+	if err != nil {
+		return fmt.Errorf("Bad JSON-RPC response: %v", err)
+	}
+
+	for _, res := range responses {
+		var id int64
+		if json.Unmarshal(res.ID, &id) != nil || byID[id] == nil {
+			if res.Error != nil { // e.g. parse error without id
+				return res.Error
+			}
+			continue
+		}
+		call := byID[id]
+		delete(byID, id)
+		if res.Error != nil {
+			call.Error = res.Error
+		} else if call.Result != nil {
+			if err := json.Unmarshal(res.Result, call.Result); err != nil {
+				call.Error = &RpcError{Code: RpcInternalError, Message: err.Error()}
+			}
+		}
+	}
+	for _, call := range byID {
+		call.Error = &RpcError{Code: RpcInternalError, Message: "No response"}
+	}
+	return nil
+}
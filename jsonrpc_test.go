@@ -0,0 +1,171 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type rpcCtxKey struct{}
+
+type greetParams struct {
+	Name  string `json:"name"`
+	Title string `json:"title"`
+}
+
+func newTestRpcServer(t *testing.T, notified *[]string, mu *sync.Mutex) *RpcServer {
+	s := NewRpcServer()
+	methods := map[string]interface{}{
+		"add": func(a, b int) (int, error) { return a + b, nil },
+		"greet": func(ctx context.Context, p greetParams) (string, error) {
+			prefix, _ := ctx.Value(rpcCtxKey{}).(string)
+			return prefix + strings.TrimSpace(p.Title+" "+p.Name), nil
+		},
+		"sum": func(nums []float64) (float64, error) {
+			total := 0.0
+			for _, n := range nums {
+				total += n
+			}
+			return total, nil
+		},
+		"lookup": func(id string) (Map, error) { return nil, NotFoundError("No item " + id) },
+		"crash":  func() error { panic("boom") },
+		"log": func(msg string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			*notified = append(*notified, msg)
+			return nil
+		},
+	}
+	for name, fn := range methods {
+		if err := s.Register(name, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.Register("bad", func() int { return 0 }) == nil || s.Register("bad", 1) == nil {
+		t.Fatal("bad methods should not register")
+	}
+	return s
+}
+
+func TestRpcServerHandle(t *testing.T) {
+	var mu sync.Mutex
+	var notified []string
+	s := newTestRpcServer(t, &notified, &mu)
+	ctx := context.WithValue(context.Background(), rpcCtxKey{}, "Hi ")
+
+	var tests = []struct {
+		req, want string
+	}{
+		// positional, named with context, single slice
+		{`{"jsonrpc":"2.0","method":"add","params":[1,2],"id":1}`, `{"jsonrpc":"2.0","result":3,"id":1}`},
+		{`{"jsonrpc":"2.0","method":"add","params":[1],"id":"a"}`, `{"jsonrpc":"2.0","result":1,"id":"a"}`},
+		{`{"jsonrpc":"2.0","method":"greet","params":{"name":"Jy","title":"Dr"},"id":2}`, `{"jsonrpc":"2.0","result":"Hi Dr Jy","id":2}`},
+		{`{"jsonrpc":"2.0","method":"greet","id":3}`, `{"jsonrpc":"2.0","result":"Hi ","id":3}`},
+		{`{"jsonrpc":"2.0","method":"sum","params":[1,2.5,3],"id":4}`, `{"jsonrpc":"2.0","result":6.5,"id":4}`},
+		// errors
+		{`{"jsonrpc":"2.0","method":"add","params":[1,2,3],"id":5}`, `{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid params","data":"Too many params: 3 > 2"},"id":5}`},
+		{`{"jsonrpc":"2.0","method":"add","params":{"a":1},"id":6}`, `{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid params","data":"Named params need a single parameter"},"id":6}`},
+		{`{"jsonrpc":"2.0","method":"add","params":["x"],"id":7}`, `{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid params","data":"Param 0: json: cannot unmarshal string into Go value of type int"},"id":7}`},
+		{`{"jsonrpc":"2.0","method":"nope","id":8}`, `{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"},"id":8}`},
+		{`{"jsonrpc":"2.0","method":"crash","id":9}`, `{"jsonrpc":"2.0","error":{"code":-32603,"message":"boom"},"id":9}`},
+		{`{"jsonrpc":"1.0","method":"add","id":10}`, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":10}`},
+		{`{"jsonrpc":"2.0","method":"add"`, `{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error"},"id":null}`},
+		// batches
+		{`[]`, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`},
+		{`[1]`, `[{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}]`},
+		{`[{"jsonrpc":"2.0","method":"add","params":[1,1],"id":1},{"jsonrpc":"2.0","method":"log","params":["b"]},{"jsonrpc":"2.0","method":"nope","id":2}]`,
+			`[{"jsonrpc":"2.0","result":2,"id":1},{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"},"id":2}]`},
+	}
+	for _, tt := range tests {
+		got := s.Handle(ctx, []byte(tt.req))
+		if !JsonContains(got, []byte(tt.want)) || !JsonContains([]byte(tt.want), got) {
+			t.Fatalf("%s: expected %s, got %s", tt.req, tt.want, got)
+		}
+	}
+
+	// notifications get no response
+	if got := s.Handle(ctx, []byte(`{"jsonrpc":"2.0","method":"log","params":["a"]}`)); got != nil {
+		t.Fatalf("expected no response, got %s", got)
+	}
+	if got := s.Handle(ctx, []byte(`[{"jsonrpc":"2.0","method":"log","params":["c"]}]`)); got != nil {
+		t.Fatalf("expected no response, got %s", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(notified) != 3 {
+		t.Fatalf("expected 3 notifications, got %v", notified)
+	}
+}
+
+func TestRpcClient(t *testing.T) {
+	var mu sync.Mutex
+	var notified []string
+	srv := httptest.NewServer(newTestRpcServer(t, &notified, &mu))
+	defer srv.Close()
+	c := NewRpcClient(srv.URL, nil)
+
+	var n int
+	if err := c.Call("add", []int{2, 3}, &n); err != nil || n != 5 {
+		t.Fatalf("bad add %d %v", n, err)
+	}
+	var s string
+	if err := c.Call("greet", greetParams{Name: "Jy"}, &s); err != nil || s != "Jy" {
+		t.Fatalf("bad greet %q %v", s, err)
+	}
+
+	// goutil.Error survives the round trip
+	err := c.Call("lookup", []string{"7"}, nil)
+	var re *RpcError
+	if !errors.As(err, &re) || re.Code != RpcServerError || !IsNotFound(re.ToError()) || re.ToError().Message != "No item 7" {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	sum, missing := &RpcCall{Method: "sum", Params: []float64{1, 2}, Result: new(float64)}, &RpcCall{Method: "nope"}
+	if err := c.Batch(sum, missing); err != nil {
+		t.Fatal(err)
+	}
+	if *sum.Result.(*float64) != 3 || sum.Error != nil || missing.Error == nil || missing.Error.Code != RpcMethodNotFound {
+		t.Fatalf("bad batch %+v %+v", sum, missing)
+	}
+
+	if err := c.Notify("log", []string{"x"}); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if len(notified) != 1 {
+		t.Fatalf("expected a notification, got %v", notified)
+	}
+	mu.Unlock()
+}
+
+func TestRpcClientHttpErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/405":
+			WriteJsonError(w, http.StatusMethodNotAllowed, "Not here")
+		default:
+			WriteJsonError(w, http.StatusInternalServerError, "Server down")
+		}
+	}))
+	defer srv.Close()
+
+	err := NewRpcClient(srv.URL+"/405", nil).Call("add", []int{1, 2}, nil)
+	if !IsMethodNotAllowed(err) {
+		t.Fatalf("expected 405, got %v", err)
+	}
+	err = NewRpcClient(srv.URL+"/500", nil).Call("add", []int{1, 2}, nil)
+	var e Error
+	if !errors.As(err, &e) || e.Code != 500 || e.Message != "Server down" {
+		t.Fatalf("expected 500, got %v", err)
+	}
+	if err := NewRpcClient(srv.URL, nil).Notify("log", nil); !IsServerError(err) {
+		t.Fatalf("expected notify to fail, got %v", err)
+	}
+}
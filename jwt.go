@@ -0,0 +1,426 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// REF: https://datatracker.ietf.org/doc/html/rfc7519
+
+const (
+	JwtHS256 = "HS256"
+	JwtRS256 = "RS256"
+	JwtES256 = "ES256"
+)
+
+var (
+	ErrJwtMalformed   = errors.New("Malformed token")
+	ErrJwtAlgorithm   = errors.New("Unsupported or disallowed signing algorithm")
+	ErrJwtKey         = errors.New("Invalid key for signing algorithm")
+	ErrJwtSignature   = errors.New("Invalid token signature")
+	ErrJwtExpired     = errors.New("Token expired")
+	ErrJwtNotYetValid = errors.New("Token not yet valid")
+	ErrJwtIssuedAt    = errors.New("Token issued in the future")
+	ErrJwtAudience    = errors.New("Invalid token audience")
+	ErrJwtIssuer      = errors.New("Invalid token issuer")
+)
+
+var jwtEncoding = base64.RawURLEncoding
+
+// JwtAudience accepts both the single string and the array form of "aud"
+type JwtAudience []string
+
+func (a JwtAudience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *JwtAudience) UnmarshalJSON(buf []byte) error {
+	var s string
+	if err := json.Unmarshal(buf, &s); err == nil {
+		*a = JwtAudience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(buf, &ss); err != nil {
+		return err
+	}
+	*a = ss
+	return nil
+}
+
+func (a JwtAudience) Contains(aud string) bool {
+	return ContainsString(a, aud)
+}
+
+// JwtStdClaims can be embedded in typed claims
+type JwtStdClaims struct {
+	Issuer    string      `json:"iss,omitempty"`
+	Subject   string      `json:"sub,omitempty"`
+	Audience  JwtAudience `json:"aud,omitempty"`
+	ExpiresAt int64       `json:"exp,omitempty"`
+	NotBefore int64       `json:"nbf,omitempty"`
+	IssuedAt  int64       `json:"iat,omitempty"`
+	ID        string      `json:"jti,omitempty"`
+}
+
+// only used for validation, numeric dates may be fractional
+type jwtValidClaims struct {
+	Issuer    string      `json:"iss"`
+	Audience  JwtAudience `json:"aud"`
+	ExpiresAt *float64    `json:"exp"`
+	NotBefore *float64    `json:"nbf"`
+	IssuedAt  *float64    `json:"iat"`
+}
+
+type JwtOptions struct {
+	Key        interface{}
+	KeyFunc    func(header Map) (interface{}, error) // overrides Key, e.g. to pick by "kid"
+	Algorithms []string                              // if empty, implied by the key type
+	Audience   string
+	Issuer     string
+	Skew       time.Duration
+	RequireExp bool
+	Now        func() time.Time
+
+	TokenFunc func(req *http.Request) string // for middleware, default to Bearer token
+}
+
+func (opts *JwtOptions) now() time.Time {
+	if opts.Now != nil {
+		return opts.Now()
+	}
+	return time.Now()
+}
+
+func JwtEncode(alg string, key interface{}, claims interface{}, headers ...Map) (string, error) {
+	header := Map{}
+	for _, h := range headers {
+		for k, v := range h {
+			header[k] = v
+		}
+	}
+	header["alg"] = alg
+	header["typ"] = "JWT"
+
+	hbuf, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	cbuf, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signing := jwtEncoding.EncodeToString(hbuf) + "." + jwtEncoding.EncodeToString(cbuf)
+	sig, err := jwtSign(alg, key, []byte(signing))
+	if err != nil {
+		return "", err
+	}
+	return signing + "." + jwtEncoding.EncodeToString(sig), nil
+}
+
+// JwtDecodeUnverified decodes claims WITHOUT checking signature or claims
+func JwtDecodeUnverified(token string, claims interface{}) (Map, error) {
+	header, payload, _, _, err := jwtSplit(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims != nil {
+		if err := json.Unmarshal(payload, claims); err != nil {
+			return header, ErrJwtMalformed
+		}
+	}
+	return header, nil
+}
+
+// JwtVerify checks signature and standard claims, then unmarshals claims into
+// ret, typically a *Map or a pointer to struct, if not nil
+func JwtVerify(token string, opts JwtOptions, ret interface{}) error {
+	header, payload, signing, sig, err := jwtSplit(token)
+	if err != nil {
+		return err
+	}
+
+	alg, _ := header["alg"].(string)
+	key := opts.Key
+	if opts.KeyFunc != nil {
+		if key, err = opts.KeyFunc(header); err != nil {
+			return err
+		}
+	}
+
+	algs := opts.Algorithms
+	if len(algs) == 0 {
+		algs = jwtKeyAlgorithms(key)
+	}
+	if !ContainsString(algs, alg) {
+		return ErrJwtAlgorithm
+	}
+	if err := jwtVerify(alg, key, signing, sig); err != nil {
+		return err
+	}
+
+	var claims jwtValidClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ErrJwtMalformed
+	}
+	if err := claims.validate(&opts); err != nil {
+		return err
+	}
+
+	if ret != nil {
+		if err := json.Unmarshal(payload, ret); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *jwtValidClaims) validate(opts *JwtOptions) error {
+	now := opts.now()
+	skew := opts.Skew
+
+	if c.ExpiresAt == nil {
+		if opts.RequireExp {
+			return ErrJwtExpired
+		}
+	} else if !now.Before(jwtTime(*c.ExpiresAt).Add(skew)) {
+		return ErrJwtExpired
+	}
+	if c.NotBefore != nil && now.Add(skew).Before(jwtTime(*c.NotBefore)) {
+		return ErrJwtNotYetValid
+	}
+	if c.IssuedAt != nil && now.Add(skew).Before(jwtTime(*c.IssuedAt)) {
+		return ErrJwtIssuedAt
+	}
+	if opts.Audience != "" && !c.Audience.Contains(opts.Audience) {
+		return ErrJwtAudience
+	}
+	if opts.Issuer != "" && c.Issuer != opts.Issuer {
+		return ErrJwtIssuer
+	}
+	return nil
+}
+
+// jwtMaxSecs bounds NumericDate values, beyond which float64 has no
+// fraction anyway, so that they convert to int64 without overflow
+const jwtMaxSecs = 1 << 53
+
+func jwtTime(secs float64) time.Time {
+	if secs > jwtMaxSecs {
+		secs = jwtMaxSecs
+	} else if secs < -jwtMaxSecs {
+		secs = -jwtMaxSecs
+	}
+	whole := math.Floor(secs)
+	return time.Unix(int64(whole), int64((secs-whole)*float64(time.Second)))
+}
+
+func jwtSplit(token string) (header Map, payload []byte, signing, sig []byte, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil, nil, nil, ErrJwtMalformed
+	}
+
+	hbuf, err := jwtEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(hbuf, &header) != nil {
+		return nil, nil, nil, nil, ErrJwtMalformed
+	}
+	if payload, err = jwtEncoding.DecodeString(parts[1]); err != nil {
+		return nil, nil, nil, nil, ErrJwtMalformed
+	}
+	if sig, err = jwtEncoding.DecodeString(parts[2]); err != nil {
+		return nil, nil, nil, nil, ErrJwtMalformed
+	}
+	return header, payload, []byte(parts[0] + "." + parts[1]), sig, nil
+}
+
+func jwtKeyAlgorithms(key interface{}) []string {
+	switch key.(type) {
+	case []byte, string:
+		return []string{JwtHS256}
+	case *rsa.PublicKey, *rsa.PrivateKey:
+		return []string{JwtRS256}
+	case *ecdsa.PublicKey, *ecdsa.PrivateKey:
+		return []string{JwtES256}
+	}
+	return nil
+}
+
+func jwtHmacKey(key interface{}) ([]byte, bool) {
+	switch k := key.(type) {
+	case []byte:
+		return k, len(k) > 0
+	case string:
+		return []byte(k), len(k) > 0
+	}
+	return nil, false
+}
+
+func jwtSign(alg string, key interface{}, signing []byte) ([]byte, error) {
+	switch alg {
+	case JwtHS256:
+		k, ok := jwtHmacKey(key)
+		if !ok {
+			return nil, ErrJwtKey
+		}
+		mac := hmac.New(sha256.New, k)
+		mac.Write(signing)
+		return mac.Sum(nil), nil
+
+	case JwtRS256:
+		k, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, ErrJwtKey
+		}
+		digest := sha256.Sum256(signing)
+		return rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+
+	case JwtES256:
+		k, ok := key.(*ecdsa.PrivateKey)
+		if !ok || k.Curve != elliptic.P256() {
+			return nil, ErrJwtKey
+		}
+		digest := sha256.Sum256(signing)
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			return nil, err
+		}
+		// fixed-size R || S, not ASN.1
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	}
+	return nil, ErrJwtAlgorithm
+}
+
+func jwtVerify(alg string, key interface{}, signing, sig []byte) error {
+	switch alg {
+	case JwtHS256:
+		expected, err := jwtSign(alg, key, signing)
+		if err != nil {
+			return err
+		}
+		if !hmac.Equal(expected, sig) {
+			return ErrJwtSignature
+		}
+		return nil
+
+	case JwtRS256:
+		var k *rsa.PublicKey
+		switch v := key.(type) {
+		case *rsa.PublicKey:
+			k = v
+		case *rsa.PrivateKey:
+			k = &v.PublicKey
+		default:
+			return ErrJwtKey
+		}
+		digest := sha256.Sum256(signing)
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) != nil {
+			return ErrJwtSignature
+		}
+		return nil
+
+	case JwtES256:
+		var k *ecdsa.PublicKey
+		switch v := key.(type) {
+		case *ecdsa.PublicKey:
+			k = v
+		case *ecdsa.PrivateKey:
+			k = &v.PublicKey
+		default:
+			return ErrJwtKey
+		}
+		if k.Curve != elliptic.P256() {
+			return ErrJwtKey
+		}
+		if len(sig) != 64 {
+			return ErrJwtSignature
+		}
+		digest := sha256.Sum256(signing)
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(k, digest[:], r, s) {
+			return ErrJwtSignature
+		}
+		return nil
+	}
+	return ErrJwtAlgorithm
+}
+
+// middleware
+
+type jwtContextKey struct{}
+
+func BearerToken(req *http.Request) string {
+	auth := req.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// JwtMiddleware verifies the request token and stores its claims (as Map)
+// in the request context, replying 401 with a JsonMsg error otherwise
+func JwtMiddleware(opts JwtOptions) func(http.Handler) http.Handler {
+	tokenFunc := opts.TokenFunc
+	if tokenFunc == nil {
+		tokenFunc = BearerToken
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			token := tokenFunc(req)
+			if token == "" {
+				jwtUnauthorized(w, "Missing token")
+				return
+			}
+			var claims Map
+			if err := JwtVerify(token, opts, &claims); err != nil {
+				jwtUnauthorized(w, err.Error())
+				return
+			}
+			next.ServeHTTP(w, req.WithContext(ContextWithJwtClaims(req.Context(), claims)))
+		})
+	}
+}
+
+func jwtUnauthorized(w http.ResponseWriter, msg string) {
+	w.Header().Set("Content-Type", ctAppJson)
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer error=%q", "invalid_token"))
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write(SimpleJsonError(msg, http.StatusUnauthorized))
+}
+
+func ContextWithJwtClaims(ctx context.Context, claims Map) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, jwtContextKey{}, claims)
+}
+
+func JwtClaimsFromContext(ctx context.Context) Map {
+	claims, _ := ctx.Value(jwtContextKey{}).(Map)
+	return claims
+}
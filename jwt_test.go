@@ -0,0 +1,139 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestJwtSignVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		alg       string
+		signKey   interface{}
+		verifyKey interface{}
+	}{
+		{JwtHS256, []byte("secret"), []byte("secret")},
+		{JwtRS256, rsaKey, &rsaKey.PublicKey},
+		{JwtES256, ecKey, &ecKey.PublicKey},
+	}
+
+	exp := time.Now().Add(time.Hour).Unix()
+	for _, tt := range tests {
+		token, err := JwtEncode(tt.alg, tt.signKey, Map{"sub": "jy", "exp": exp})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.alg, err)
+		}
+
+		var claims Map
+		if err := JwtVerify(token, JwtOptions{Key: tt.verifyKey}, &claims); err != nil {
+			t.Fatalf("%s: %v", tt.alg, err)
+		}
+		if claims["sub"] != "jy" {
+			t.Fatalf("%s: bad claims %v", tt.alg, claims)
+		}
+
+		var std JwtStdClaims
+		if err := JwtVerify(token, JwtOptions{Key: tt.verifyKey}, &std); err != nil || std.ExpiresAt != exp {
+			t.Fatalf("%s: bad typed claims %+v %v", tt.alg, std, err)
+		}
+
+		tampered := token[:len(token)-4] + "AAAA"
+		if err := JwtVerify(tampered, JwtOptions{Key: tt.verifyKey}, nil); err == nil {
+			t.Fatalf("%s: tampered token should not verify", tt.alg)
+		}
+	}
+
+	// ES256 requires P-256
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if _, err := JwtEncode(JwtES256, p384, Map{}); !errors.Is(err, ErrJwtKey) {
+		t.Fatalf("expected key error, got %v", err)
+	}
+	token, _ := JwtEncode(JwtES256, ecKey, Map{})
+	if err := JwtVerify(token, JwtOptions{Key: &p384.PublicKey}, nil); !errors.Is(err, ErrJwtKey) {
+		t.Fatalf("expected key error, got %v", err)
+	}
+
+	// algorithm implied by key type
+	token, _ = JwtEncode(JwtHS256, []byte("secret"), Map{})
+	if err := JwtVerify(token, JwtOptions{Key: &rsaKey.PublicKey}, nil); !errors.Is(err, ErrJwtAlgorithm) {
+		t.Fatalf("expected algorithm error, got %v", err)
+	}
+}
+
+func TestJwtClaims(t *testing.T) {
+	key := []byte("secret")
+	now := time.Unix(1600000000, 0)
+	opts := JwtOptions{
+		Key:      key,
+		Audience: "api",
+		Issuer:   "auth",
+		Skew:     30 * time.Second,
+		Now:      func() time.Time { return now },
+	}
+
+	var tests = []struct {
+		claims Map
+		err    error
+	}{
+		{Map{"aud": "api", "iss": "auth", "exp": now.Unix() + 60}, nil},
+		{Map{"aud": []string{"x", "api"}, "iss": "auth"}, nil},
+		{Map{"aud": "api", "iss": "auth", "exp": now.Unix() - 10}, nil},
+		{Map{"aud": "api", "iss": "auth", "exp": now.Unix() - 60}, ErrJwtExpired},
+		{Map{"aud": "api", "iss": "auth", "nbf": now.Unix() + 60}, ErrJwtNotYetValid},
+		{Map{"aud": "api", "iss": "auth", "nbf": 1e11}, ErrJwtNotYetValid},
+		{Map{"aud": "api", "iss": "auth", "nbf": 1e300}, ErrJwtNotYetValid},
+		{Map{"aud": "api", "iss": "auth", "exp": 1e300}, nil},
+		{Map{"aud": "api", "iss": "auth", "exp": -1e300}, ErrJwtExpired},
+		{Map{"aud": "api", "iss": "auth", "iat": now.Unix() + 60}, ErrJwtIssuedAt},
+		{Map{"aud": "web", "iss": "auth"}, ErrJwtAudience},
+		{Map{"aud": "api", "iss": "other"}, ErrJwtIssuer},
+	}
+
+	for _, tt := range tests {
+		token, err := JwtEncode(JwtHS256, key, tt.claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := JwtVerify(token, opts, nil); err != tt.err {
+			t.Fatalf("%v: expected %v, got %v", tt.claims, tt.err, err)
+		}
+	}
+}
+
+func TestJwtMiddleware(t *testing.T) {
+	key := []byte("secret")
+	handler := JwtMiddleware(JwtOptions{Key: key})(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(JwtClaimsFromContext(req.Context())["sub"].(string)))
+	}))
+
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	w := NewResponseWriter()
+	handler.ServeHTTP(w, req)
+	if w.StatusCode() != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.StatusCode())
+	}
+
+	token, _ := JwtEncode(JwtHS256, key, Map{"sub": "jy"})
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = NewResponseWriter()
+	handler.ServeHTTP(w, req)
+	if w.StatusCode() != http.StatusOK || w.String() != "jy" {
+		t.Fatalf("unexpected response %d %s", w.StatusCode(), w.String())
+	}
+}
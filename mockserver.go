@@ -0,0 +1,284 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MockServer is a programmable fake server for tests, e.g.
+//
+//	srv := NewMockServer()
+//	defer srv.Close()
+//	srv.Expect("POST", "/v1/items").WithBody(Map{"name": "x"}).ReplyData(StrMap{"id": "1"})
+//	... calls to srv.URL ...
+//	srv.AssertExpectations(t)
+type MockServer struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	expectations []*MockExpectation
+	unexpected   []string
+}
+
+// TestingT is the subset of testing.TB used here
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+func NewMockServer() *MockServer {
+	s := &MockServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Expect registers an expectation matched in order of registration; it is
+// expected once unless changed with Times or AnyTimes
+func (s *MockServer) Expect(method, path string) *MockExpectation {
+	e := &MockExpectation{
+		method:     strings.ToUpper(method),
+		path:       path,
+		times:      1,
+		statusCode: http.StatusOK,
+		header:     http.Header{},
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expectations = append(s.expectations, e)
+	return e
+}
+
+func (s *MockServer) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expectations = nil
+	s.unexpected = nil
+}
+
+// Verify reports unmet expectations and unexpected calls
+func (s *MockServer) Verify() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var msgs []string
+	for _, e := range s.expectations {
+		if e.err != nil {
+			msgs = append(msgs, fmt.Sprintf("bad expectation %s: %v", e, e.err))
+		}
+		if e.times >= 0 && e.calls < e.times {
+			msgs = append(msgs, fmt.Sprintf("expected %s called %d times, got %d", e, e.times, e.calls))
+		}
+	}
+	for _, call := range s.unexpected {
+		msgs = append(msgs, "unexpected call "+call)
+	}
+	if len(msgs) > 0 {
+		return fmt.Errorf("%s", strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+func (s *MockServer) AssertExpectations(t TestingT) {
+	t.Helper()
+	if err := s.Verify(); err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+func (s *MockServer) serve(w http.ResponseWriter, req *http.Request) {
+	body, _ := ReadRequestBody(req)
+
+	s.mu.Lock()
+	var match *MockExpectation
+	for _, e := range s.expectations {
+		if e.exhausted() || !e.matches(req, body) {
+			continue
+		}
+		match = e
+		break
+	}
+	if match == nil {
+		s.unexpected = append(s.unexpected, fmt.Sprintf("%s %s %s", req.Method, req.URL, body))
+	} else {
+		match.calls++
+	}
+	s.mu.Unlock()
+
+	if match == nil {
+		WriteJsonError(w, http.StatusNotImplemented, "No matching expectation")
+		return
+	}
+	match.reply(w)
+}
+
+type MockExpectation struct {
+	method  string
+	path    string
+	query   StrMap
+	headers StrMap
+	body    []byte
+	times   int // <0 for any
+	calls   int
+
+	statusCode int
+	header     http.Header
+	respBody   []byte
+	delay      time.Duration
+	fail       bool
+	err        error // marshaling a body, replied as 500
+}
+
+func (e *MockExpectation) String() string {
+	if e.body != nil {
+		return fmt.Sprintf("%s %s %s", e.method, e.path, e.body)
+	}
+	return e.method + " " + e.path
+}
+
+// WithBody matches request bodies containing body per JsonContains; body
+// can be json in []byte or string, or anything to marshal
+func (e *MockExpectation) WithBody(body interface{}) *MockExpectation {
+	e.body = e.mockJson(body)
+	return e
+}
+
+func (e *MockExpectation) WithQuery(key, value string) *MockExpectation {
+	if e.query == nil {
+		e.query = StrMap{}
+	}
+	e.query[key] = value
+	return e
+}
+
+func (e *MockExpectation) WithHeader(key, value string) *MockExpectation {
+	if e.headers == nil {
+		e.headers = StrMap{}
+	}
+	e.headers[key] = value
+	return e
+}
+
+func (e *MockExpectation) Times(n int) *MockExpectation {
+	e.times = n
+	return e
+}
+
+func (e *MockExpectation) AnyTimes() *MockExpectation {
+	e.times = -1
+	return e
+}
+
+// Reply sets the response; body is handled as in WithBody
+func (e *MockExpectation) Reply(statusCode int, body interface{}) *MockExpectation {
+	e.statusCode = statusCode
+	e.respBody = e.mockJson(body)
+	if e.header.Get("Content-Type") == "" {
+		e.header.Set("Content-Type", ctAppJson)
+	}
+	return e
+}
+
+func (e *MockExpectation) ReplyJson(msg JsonMsg) *MockExpectation {
+	statusCode := http.StatusOK
+	if msg.Error.Code > 0 {
+		statusCode = msg.Error.Code
+	}
+	return e.Reply(statusCode, msg)
+}
+
+func (e *MockExpectation) ReplyData(values StrMap, kinds ...string) *MockExpectation {
+	return e.Reply(http.StatusOK, SimpleJsonData(values, kinds...))
+}
+
+func (e *MockExpectation) ReplyError(statusCode int, msg string) *MockExpectation {
+	return e.Reply(statusCode, SimpleJsonError(msg, statusCode))
+}
+
+func (e *MockExpectation) ReplyHeader(key, value string) *MockExpectation {
+	e.header.Set(key, value)
+	return e
+}
+
+func (e *MockExpectation) Delay(d time.Duration) *MockExpectation {
+	e.delay = d
+	return e
+}
+
+// Fail drops the connection without response, for client-side errors; as
+// net/http retries idempotent requests on reused connections, use AnyTimes
+// or Times(2) for those
+func (e *MockExpectation) Fail() *MockExpectation {
+	e.fail = true
+	return e
+}
+
+func (e *MockExpectation) exhausted() bool {
+	return e.times >= 0 && e.calls >= e.times
+}
+
+func (e *MockExpectation) matches(req *http.Request, body []byte) bool {
+	if e.method != req.Method || e.path != req.URL.Path {
+		return false
+	}
+	q := req.URL.Query()
+	for k, v := range e.query {
+		if q.Get(k) != v {
+			return false
+		}
+	}
+	for k, v := range e.headers {
+		if req.Header.Get(k) != v {
+			return false
+		}
+	}
+	return e.body == nil || e.err != nil || JsonContains(body, e.body)
+}
+
+func (e *MockExpectation) reply(w http.ResponseWriter) {
+	if e.delay > 0 {
+		time.Sleep(e.delay)
+	}
+	if e.fail {
+		if hj, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hj.Hijack(); err == nil {
+				conn.Close()
+				return
+			}
+		}
+		panic(http.ErrAbortHandler)
+	}
+	if e.err != nil {
+		WriteJsonError(w, http.StatusInternalServerError, fmt.Sprintf("Bad expectation %s: %v", e, e.err))
+		return
+	}
+	for k, v := range e.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(e.statusCode)
+	w.Write(e.respBody)
+}
+
+// mockJson keeps a marshal error for the reply instead of panicking in a
+// test helper
+func (e *MockExpectation) mockJson(v interface{}) []byte {
+	switch b := v.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return b
+	case string:
+		return []byte(b)
+	}
+	buf, err := json.Marshal(v)
+	if err != nil && e.err == nil {
+		e.err = err
+	}
+	return buf
+}
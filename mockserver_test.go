@@ -0,0 +1,87 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMockServer(t *testing.T) {
+	srv := NewMockServer()
+	defer srv.Close()
+
+	srv.Expect("POST", "/items").WithBody(Map{"name": "x"}).ReplyData(StrMap{"id": "1"}, "item")
+	srv.Expect("GET", "/items").WithQuery("q", "a").WithHeader("X-Key", "k").
+		Reply(http.StatusOK, `{"apiVersion":"2"}`).ReplyHeader("X-Total", "3").Times(2)
+	srv.Expect("GET", "/missing").ReplyError(http.StatusNotFound, "Not here").AnyTimes()
+
+	var msg JsonMsg
+	if err := AjaxPostUnmarshal(srv.URL+"/items", []byte(`{"name":"x","extra":1}`), nil, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Data.Kind != "item" {
+		t.Fatalf("bad data reply %+v", msg)
+	}
+
+	for i := 0; i < 2; i++ {
+		res, err := HttpDo("GET", srv.URL+"/items?q=a", "", nil, StrMap{"X-Key": "k"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ReadResponseBody(res)
+		if res.Header.Get("X-Total") != "3" || string(body) != `{"apiVersion":"2"}` {
+			t.Fatalf("bad reply %v %s", res.Header, body)
+		}
+	}
+
+	err := AjaxGetUnmarshal(srv.URL+"/missing", nil, &msg)
+	if !IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if err := srv.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	// exhausted, unmatched and unmet expectations
+	srv.Expect("DELETE", "/items/1")
+	res, err := HttpDo("GET", srv.URL+"/items?q=a", "", nil, StrMap{"X-Key": "k"})
+	if err != nil || res.StatusCode != http.StatusNotImplemented {
+		t.Fatalf("exhausted expectations should not match: %v %v", res, err)
+	}
+	res.Body.Close()
+
+	rt := &recordingT{}
+	srv.AssertExpectations(rt)
+	if len(rt.errors) != 1 || !strings.Contains(rt.errors[0], "unexpected call GET") ||
+		!strings.Contains(rt.errors[0], "expected DELETE /items/1 called 1 times, got 0") {
+		t.Fatalf("bad verification %v", rt.errors)
+	}
+
+	srv.Reset()
+	if err := srv.Verify(); err != nil {
+		t.Fatalf("reset should clear expectations, got %v", err)
+	}
+}
+
+func TestMockServerErrors(t *testing.T) {
+	srv := NewMockServer()
+	defer srv.Close()
+
+	// bad reply bodies give 500 instead of panicking
+	srv.Expect("GET", "/bad").Reply(http.StatusOK, Map{"ch": make(chan int)})
+	err := AjaxGetUnmarshal(srv.URL+"/bad", nil, nil)
+	if !IsInternalServerError(err) || !strings.Contains(err.Error(), "Bad expectation GET /bad") {
+		t.Fatalf("expected internal error, got %v", err)
+	}
+	if err := srv.Verify(); err == nil || !strings.Contains(err.Error(), "bad expectation") {
+		t.Fatalf("expected bad expectation, got %v", err)
+	}
+
+	srv.Reset()
+	srv.Expect("GET", "/fail").Fail().AnyTimes()
+	if _, err := HttpDo("GET", srv.URL+"/fail", "", nil, nil); err == nil {
+		t.Fatal("expected connection failure")
+	}
+}
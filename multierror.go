@@ -0,0 +1,144 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MultiError collects errors, e.g. from validations or batch jobs; it is safe
+// for concurrent appends and flattens nested MultiErrors
+type MultiError struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func NewMultiError(errs ...error) *MultiError {
+	m := &MultiError{}
+	m.Append(errs...)
+	return m
+}
+
+// Append skips nil errors
+func (m *MultiError) Append(errs ...error) *MultiError {
+	flat := flattenErrors(nil, errs)
+	m.mu.Lock()
+	m.errs = append(m.errs, flat...)
+	m.mu.Unlock()
+	return m
+}
+
+func flattenErrors(ret []error, errs []error) []error {
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if sub, ok := err.(*MultiError); ok {
+			if sub != nil {
+				ret = flattenErrors(ret, sub.Errors())
+			}
+			continue
+		}
+		ret = append(ret, err)
+	}
+	return ret
+}
+
+func (m *MultiError) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.errs)
+}
+
+// Errors returns a copy of the members
+func (m *MultiError) Errors() []error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]error(nil), m.errs...)
+}
+
+// ErrorOrNil returns nil if there are no members, to be returned as error
+func (m *MultiError) ErrorOrNil() error {
+	if m == nil || m.Len() == 0 {
+		return nil
+	}
+	return m
+}
+
+func (m *MultiError) Error() string {
+	errs := m.Errors()
+	switch len(errs) {
+	case 0:
+		return "no errors"
+	case 1:
+		return errs[0].Error()
+	}
+	var sb strings.Builder
+	sb.WriteString(strconv.Itoa(len(errs)))
+	sb.WriteString(" errors occurred:")
+	for _, err := range errs {
+		sb.WriteString("\n\t* ")
+		sb.WriteString(strings.ReplaceAll(err.Error(), "\n", "\n\t  "))
+	}
+	return sb.String()
+}
+
+// Is reports if any member matches target
+func (m *MultiError) Is(target error) bool {
+	for _, err := range m.Errors() {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first member that matches target
+func (m *MultiError) As(target interface{}) bool {
+	for _, err := range m.Errors() {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// ToError converts to Error with one ErrorItem per member; code 0 takes the
+// code shared by all members, or InternalServerError
+func (m *MultiError) ToError(code int) Error {
+	errs := m.Errors()
+	if code == 0 {
+		for i, err := range errs {
+			c := ErrorCode(err, InternalServerError)
+			if i > 0 && c != code {
+				code = InternalServerError
+				break
+			}
+			code = c
+		}
+		if code == 0 {
+			code = InternalServerError
+		}
+	}
+
+	msg := "no errors"
+	if len(errs) == 1 {
+		msg = errs[0].Error()
+	} else if len(errs) > 1 {
+		msg = strconv.Itoa(len(errs)) + " errors occurred"
+	}
+
+	items := make([]ErrorItem, len(errs))
+	for i, err := range errs {
+		items[i].Message = err.Error()
+		var e Error
+		if errors.As(err, &e) && len(e.Errors) == 1 {
+			items[i].Domain = e.Errors[0].Domain
+			items[i].Reason = e.Errors[0].Reason
+		}
+	}
+	return StdError(code, msg, items...)
+}
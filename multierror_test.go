@@ -0,0 +1,56 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestMultiError(t *testing.T) {
+	m := NewMultiError()
+	if m.ErrorOrNil() != nil {
+		t.Fatal("empty should be nil")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.Append(BadRequestError("bad"), nil)
+		}()
+	}
+	wg.Wait()
+	if m.Len() != 10 {
+		t.Fatalf("expected 10, got %d", m.Len())
+	}
+
+	inner := NewMultiError(io.EOF, StdError(NotFound, "gone", ErrorItem{Message: "x", Reason: "missing"}))
+	m = NewMultiError(BadRequestError("first"), inner)
+	if m.Len() != 3 {
+		t.Fatalf("nested should flatten, got %d", m.Len())
+	}
+	if m.Error() != "3 errors occurred:\n\t* first\n\t* EOF\n\t* gone" {
+		t.Fatalf("bad format %q", m.Error())
+	}
+
+	var err error = m
+	if !errors.Is(err, io.EOF) || !IsNotFound(err) || errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatal("Is should check members")
+	}
+	var e Error
+	if !errors.As(err, &e) || e.Message != "first" {
+		t.Fatalf("As should find the first member, got %v", e)
+	}
+
+	e = m.ToError(0)
+	if e.Code != InternalServerError || len(e.Errors) != 3 || e.Errors[2].Reason != "missing" {
+		t.Fatalf("bad conversion %+v", e)
+	}
+	if e = NewMultiError(BadRequestError(), BadRequestError()).ToError(0); e.Code != BadRequest {
+		t.Fatalf("shared code expected, got %d", e.Code)
+	}
+}
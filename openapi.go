@@ -0,0 +1,420 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// REF: https://spec.openapis.org/oas/v3.0.3
+
+type JsonSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Nullable             bool                   `json:"nullable,omitempty"`
+	Properties           map[string]*JsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Items                *JsonSchema            `json:"items,omitempty"`
+	AdditionalProperties *JsonSchema            `json:"additionalProperties,omitempty"`
+	AllOf                []*JsonSchema          `json:"allOf,omitempty"`
+}
+
+type OpenApiOperation struct {
+	Method      string
+	Path        string // router pattern, e.g. "/users/{id}"
+	Summary     string
+	Description string
+	Tags        []string
+	Query       []string // query parameter names
+
+	Request    interface{} // sample value or reflect.Type of the json body
+	Response   interface{} // sample value or reflect.Type of the json response
+	StatusCode int         // default 200
+	Envelope   bool        // response as JsonMsg data items
+}
+
+// OpenApi builds an OpenAPI 3 document from operations and Go types; struct
+// types become reusable component schemas, including JsonMsg
+type OpenApi struct {
+	Title       string
+	Version     string
+	Description string
+	Servers     []string
+
+	mu      sync.Mutex
+	ops     []OpenApiOperation
+	schemas map[string]*JsonSchema
+	names   map[reflect.Type]string
+}
+
+func NewOpenApi(title, version string) *OpenApi {
+	return &OpenApi{
+		Title:   title,
+		Version: version,
+		schemas: map[string]*JsonSchema{},
+		names:   map[reflect.Type]string{},
+	}
+}
+
+func (o *OpenApi) Add(ops ...OpenApiOperation) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.ops = append(o.ops, ops...)
+}
+
+// AddRoutes adds routes not yet described by Add
+func (o *OpenApi) AddRoutes(r *Router) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, rt := range r.Routes() {
+		found := false
+		for _, op := range o.ops {
+			if strings.EqualFold(op.Method, rt.Method) && joinRoutePath("", op.Path) == rt.Pattern {
+				found = true
+				break
+			}
+		}
+		if !found {
+			o.ops = append(o.ops, OpenApiOperation{Method: rt.Method, Path: rt.Pattern})
+		}
+	}
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// Schema returns the schema for a sample value or reflect.Type, registering
+// component schemas for named struct types
+func (o *OpenApi) Schema(v interface{}) *JsonSchema {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.schema(v)
+}
+
+func (o *OpenApi) schema(v interface{}) *JsonSchema {
+	t, ok := v.(reflect.Type)
+	if !ok {
+		t = reflect.TypeOf(v)
+	}
+	if t == nil {
+		return &JsonSchema{}
+	}
+	return o.typeSchema(t)
+}
+
+func (o *OpenApi) typeSchema(t reflect.Type) *JsonSchema {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		nullable = true
+	}
+
+	switch t {
+	case timeType:
+		return &JsonSchema{Type: "string", Format: "date-time", Nullable: nullable}
+	case rawMessageType:
+		return &JsonSchema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &JsonSchema{Type: "boolean", Nullable: nullable}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return &JsonSchema{Type: "integer", Format: "int32", Nullable: nullable}
+	case reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64: // uint32 overflows int32
+		return &JsonSchema{Type: "integer", Format: "int64", Nullable: nullable}
+	case reflect.Float32:
+		return &JsonSchema{Type: "number", Format: "float", Nullable: nullable}
+	case reflect.Float64:
+		return &JsonSchema{Type: "number", Format: "double", Nullable: nullable}
+	case reflect.String:
+		return &JsonSchema{Type: "string", Nullable: nullable}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &JsonSchema{Type: "string", Format: "byte", Nullable: nullable}
+		}
+		return &JsonSchema{Type: "array", Items: o.typeSchema(t.Elem()), Nullable: t.Kind() == reflect.Slice}
+	case reflect.Map:
+		return &JsonSchema{Type: "object", AdditionalProperties: o.typeSchema(t.Elem()), Nullable: true}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return o.structSchema(t)
+		}
+		return &JsonSchema{Ref: "#/components/schemas/" + o.component(t)}
+	}
+	return &JsonSchema{} // interface{} and the like
+}
+
+func (o *OpenApi) component(t reflect.Type) string {
+	if name, ok := o.names[t]; ok {
+		return name
+	}
+	name := t.Name()
+	if _, taken := o.schemas[name]; taken {
+		name = strings.ReplaceAll(t.PkgPath(), "/", ".") + "." + name
+	}
+	o.names[t] = name
+	o.schemas[name] = &JsonSchema{} // placeholder for recursive types
+	*o.schemas[name] = *o.structSchema(t)
+	return name
+}
+
+func (o *OpenApi) structSchema(t reflect.Type) *JsonSchema {
+	s := &JsonSchema{Type: "object", Properties: map[string]*JsonSchema{}}
+	for _, f := range dominantFields(t) {
+		fs := o.typeSchema(f.Type)
+		if strings.Contains(f.opts, "string") && fs.Type != "" && fs.Type != "object" && fs.Type != "array" {
+			fs = &JsonSchema{Type: "string"}
+		}
+		if desc := f.Tag.Get("description"); desc != "" {
+			if fs.Ref != "" { // siblings of $ref are ignored in 3.0
+				fs = &JsonSchema{AllOf: []*JsonSchema{fs}}
+			}
+			fs.Description = desc
+		}
+		s.Properties[f.name] = fs
+		if !strings.Contains(f.opts, "omitempty") && f.Type.Kind() != reflect.Ptr {
+			s.Required = append(s.Required, f.name)
+		}
+	}
+	sort.Strings(s.Required)
+	return s
+}
+
+type schemaField struct {
+	reflect.StructField
+	name   string
+	opts   string
+	depth  int
+	tagged bool
+}
+
+// follows encoding/json rules for tags and embedded structs: among fields of
+// the same name the shallowest wins, then the tagged one, otherwise none
+func dominantFields(t reflect.Type) []schemaField {
+	var all []schemaField
+	collectFields(t, 0, map[reflect.Type]bool{}, &all)
+
+	byName := map[string][]schemaField{}
+	var names []string
+	for _, f := range all {
+		if byName[f.name] == nil {
+			names = append(names, f.name)
+		}
+		byName[f.name] = append(byName[f.name], f)
+	}
+
+	var fields []schemaField
+	for _, name := range names {
+		fs := byName[name]
+		depth := fs[0].depth
+		for _, f := range fs[1:] {
+			if f.depth < depth {
+				depth = f.depth
+			}
+		}
+		var shallow, tagged []schemaField
+		for _, f := range fs {
+			if f.depth == depth {
+				shallow = append(shallow, f)
+				if f.tagged {
+					tagged = append(tagged, f)
+				}
+			}
+		}
+		switch {
+		case len(shallow) == 1:
+			fields = append(fields, shallow[0])
+		case len(tagged) == 1:
+			fields = append(fields, tagged[0])
+		}
+	}
+	return fields
+}
+
+func collectFields(t reflect.Type, depth int, visiting map[reflect.Type]bool, out *[]schemaField) {
+	if visiting[t] { // cyclic embedding
+		return
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := CutHalf(tag, ',')
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				collectFields(ft, depth+1, visiting, out)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		tagged := name != ""
+		if !tagged {
+			name = f.Name
+		}
+		*out = append(*out, schemaField{f, name, opts, depth, tagged})
+	}
+}
+
+func (o *OpenApi) envelope(payload *JsonSchema) *JsonSchema {
+	return &JsonSchema{AllOf: []*JsonSchema{
+		o.typeSchema(reflect.TypeOf(JsonMsg{})),
+		{
+			Type: "object",
+			Properties: map[string]*JsonSchema{
+				"data": {
+					Type: "object",
+					Properties: map[string]*JsonSchema{
+						"items": {Type: "array", Items: payload},
+					},
+				},
+			},
+		},
+	}}
+}
+
+// Document builds the OpenAPI document
+func (o *OpenApi) Document() Map {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	jsonMsgRef := o.typeSchema(reflect.TypeOf(JsonMsg{}))
+	paths := map[string]Map{}
+	for _, op := range o.ops {
+		path, params := openApiPath(op.Path)
+		operation := Map{}
+		if op.Summary != "" {
+			operation["summary"] = op.Summary
+		}
+		if op.Description != "" {
+			operation["description"] = op.Description
+		}
+		if len(op.Tags) > 0 {
+			operation["tags"] = op.Tags
+		}
+
+		var parameters []Map
+		for _, p := range params {
+			param := Map{
+				"name": p.name, "in": "path", "required": true, "schema": &JsonSchema{Type: "string"},
+			}
+			if p.kind == segWildcard {
+				param["description"] = "Rest of the path; OpenAPI path parameters cannot contain '/', so clients may need to escape it"
+			}
+			parameters = append(parameters, param)
+		}
+		for _, q := range op.Query {
+			parameters = append(parameters, Map{
+				"name": q, "in": "query", "schema": &JsonSchema{Type: "string"},
+			})
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+
+		if op.Request != nil {
+			operation["requestBody"] = Map{
+				"required": true,
+				"content":  Map{ctAppJson: Map{"schema": o.schema(op.Request)}},
+			}
+		}
+
+		status := op.StatusCode
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := Map{"description": http.StatusText(status)}
+		if op.Response != nil {
+			schema := o.schema(op.Response)
+			if op.Envelope {
+				schema = o.envelope(schema)
+			}
+			success["content"] = Map{ctAppJson: Map{"schema": schema}}
+		}
+		operation["responses"] = Map{
+			strconv.Itoa(status): success,
+			"default": Map{
+				"description": "Error",
+				"content":     Map{ctAppJson: Map{"schema": jsonMsgRef}},
+			},
+		}
+
+		if paths[path] == nil {
+			paths[path] = Map{}
+		}
+		paths[path][strings.ToLower(op.Method)] = operation
+	}
+
+	info := Map{"title": o.Title, "version": o.Version}
+	if o.Description != "" {
+		info["description"] = o.Description
+	}
+	doc := Map{
+		"openapi":    "3.0.3",
+		"info":       info,
+		"paths":      paths,
+		"components": Map{"schemas": o.schemas},
+	}
+	if len(o.Servers) > 0 {
+		var servers []Map
+		for _, url := range o.Servers {
+			servers = append(servers, Map{"url": url})
+		}
+		doc["servers"] = servers
+	}
+	return doc
+}
+
+func (o *OpenApi) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	buf, err := json.MarshalIndent(o.Document(), "", "  ")
+	if err != nil {
+		WriteJsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", ctAppJson)
+	w.Write(buf)
+}
+
+// converts router patterns, returning path parameters; OpenAPI has no
+// multi-segment parameters, so wildcards become ordinary ones
+func openApiPath(pattern string) (string, []routeSegment) {
+	segs := parseRoutePattern(pattern)
+	parts := make([]string, len(segs))
+	var params []routeSegment
+	for i, seg := range segs {
+		switch seg.kind {
+		case segStatic:
+			parts[i] = seg.name
+		default:
+			if seg.name == "*" {
+				seg.name = "path"
+			}
+			parts[i] = "{" + seg.name + "}"
+			params = append(params, seg)
+		}
+	}
+	return "/" + strings.Join(parts, "/"), params
+}
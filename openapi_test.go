@@ -0,0 +1,158 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type apiBase struct {
+	ID      string `json:"id"`
+	Kind    string
+	Created time.Time `json:"created"`
+}
+
+type apiAudit struct {
+	Note  string
+	Label string
+}
+
+type apiOwner struct {
+	Note  string `json:"Note"` // tagged wins over apiAudit.Note
+	Label string // same depth as apiAudit.Label, dropped
+}
+
+type apiUser struct {
+	Kind string `description:"User kind"` // shadows apiBase.Kind
+	apiBase
+	*apiAudit
+	Name    string            `json:"name" description:"Display name"`
+	Email   string            `json:"email,omitempty"`
+	Age     int               `json:"age,string"`
+	Manager *apiUser          `json:"manager"`
+	Tags    []string          `json:"tags"`
+	Attrs   map[string]int    `json:"attrs,omitempty"`
+	Raw     json.RawMessage   `json:"raw,omitempty"`
+	Owner   apiOwner          `json:"owner"`
+	Extra   map[string]string `json:"-"`
+	secret  string
+}
+
+type apiConflict struct {
+	apiAudit
+	apiOwner
+}
+
+func TestOpenApiSchema(t *testing.T) {
+	o := NewOpenApi("Test", "1.0")
+	if s := o.Schema(apiUser{}); s.Ref != "#/components/schemas/apiUser" {
+		t.Fatalf("expected a ref, got %+v", s)
+	}
+	user := o.schemas["apiUser"]
+	if user == nil || user.Type != "object" {
+		t.Fatalf("expected apiUser component, got %v", o.schemas)
+	}
+
+	props := map[string]JsonSchema{
+		"id":      {Type: "string"},
+		"Kind":    {Type: "string", Description: "User kind"},
+		"name":    {Type: "string", Description: "Display name"},
+		"created": {Type: "string", Format: "date-time"},
+		"Note":    {Type: "string"},
+		"Label":   {Type: "string"},
+		"email":   {Type: "string"},
+		"age":     {Type: "string"},
+		"manager": {Ref: "#/components/schemas/apiUser"},
+		"tags":    {Type: "array", Items: &JsonSchema{Type: "string"}, Nullable: true},
+		"attrs":   {Type: "object", AdditionalProperties: &JsonSchema{Type: "integer", Format: "int32"}, Nullable: true},
+		"raw":     {},
+		"owner":   {Ref: "#/components/schemas/apiOwner"},
+	}
+	if len(user.Properties) != len(props) {
+		t.Fatalf("expected %d properties, got %v", len(props), user.Properties)
+	}
+	for name, want := range props {
+		if got := user.Properties[name]; got == nil || !reflect.DeepEqual(*got, want) {
+			t.Fatalf("%s: expected %+v, got %+v", name, want, got)
+		}
+	}
+	required := []string{"Kind", "Label", "Note", "age", "created", "id", "name", "owner", "tags"}
+	if !reflect.DeepEqual(user.Required, required) {
+		t.Fatalf("expected required %v, got %v", required, user.Required)
+	}
+
+	// same depth: tagged wins, otherwise ambiguous fields are dropped
+	o.Schema(apiConflict{})
+	conflict := o.schemas["apiConflict"]
+	if len(conflict.Properties) != 1 || conflict.Properties["Note"] == nil {
+		t.Fatalf("expected only Note, got %v", conflict.Properties)
+	}
+	buf, _ := json.Marshal(apiConflict{apiAudit{"a", "b"}, apiOwner{"c", "d"}})
+	if string(buf) != `{"Note":"c"}` {
+		t.Fatalf("schema should match encoding/json, got %s", buf)
+	}
+
+	for v, format := range map[interface{}]string{uint16(0): "int32", uint32(0): "int64", uint(0): "int64"} {
+		if s := o.Schema(v); s.Type != "integer" || s.Format != format {
+			t.Fatalf("%T: expected %s, got %+v", v, format, s)
+		}
+	}
+}
+
+func TestOpenApiDocument(t *testing.T) {
+	r := NewRouter()
+	ok := func(w http.ResponseWriter, req *http.Request) {}
+	r.Get("/users/{id}", ok)
+	r.Get("/files/{path...}", ok)
+	r.Post("/users", ok)
+
+	o := NewOpenApi("Test", "1.0")
+	o.Add(OpenApiOperation{
+		Method: "GET", Path: "/users/{id}", Summary: "Get a user", Query: []string{"fields"},
+		Response: apiUser{}, Envelope: true,
+	}, OpenApiOperation{
+		Method: "POST", Path: "/users", Request: &apiUser{}, Response: reflect.TypeOf(apiUser{}),
+		StatusCode: http.StatusCreated,
+	})
+	o.AddRoutes(r)
+
+	w := NewResponseWriter()
+	o.ServeHTTP(w, nil)
+	var doc Map
+	if err := json.Unmarshal(w.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		pointer string
+		want    interface{}
+	}{
+		{"/openapi", "3.0.3"},
+		{"/info/title", "Test"},
+		{"/paths/~1users~1{id}/get/summary", "Get a user"},
+		{"/paths/~1users~1{id}/get/parameters/0/name", "id"},
+		{"/paths/~1users~1{id}/get/parameters/1/in", "query"},
+		{"/paths/~1users~1{id}/get/responses/200/content/application~1json/schema/allOf/0/$ref", "#/components/schemas/JsonMsg"},
+		{"/paths/~1users~1{id}/get/responses/200/content/application~1json/schema/allOf/1/properties/data/properties/items/items/$ref", "#/components/schemas/apiUser"},
+		{"/paths/~1users~1{id}/get/responses/default/content/application~1json/schema/$ref", "#/components/schemas/JsonMsg"},
+		{"/paths/~1users/post/requestBody/content/application~1json/schema/$ref", "#/components/schemas/apiUser"},
+		{"/paths/~1users/post/responses/201/description", "Created"},
+		{"/paths/~1files~1{path}/get/parameters/0/name", "path"},
+		{"/components/schemas/JsonMsg/type", "object"},
+	}
+	for _, tt := range tests {
+		got, err := JsonPointerGet(doc, tt.pointer)
+		if err != nil || got != tt.want {
+			t.Fatalf("%s: expected %v, got %v %v", tt.pointer, tt.want, got, err)
+		}
+	}
+	if desc, _ := JsonPointerGet(doc, "/paths/~1files~1{path}/get/parameters/0/description"); desc == nil {
+		t.Fatal("expected the wildcard limitation to be described")
+	}
+	if desc, _ := JsonPointerGet(doc, "/paths/~1users~1{id}/get/parameters/0/description"); desc != nil {
+		t.Fatalf("unexpected description %v", desc)
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+)
+
+// paging per the Google JSON style guide, with query params "page" (1-based),
+// "offset" (0-based, instead of page), "limit" and "cursor"
+
+type PageParams struct {
+	Page   int // 1-based
+	Offset int // 0-based index of the first item
+	Limit  int
+	Cursor string
+}
+
+type PageOptions struct {
+	DefaultLimit int // default 20
+	MaxLimit     int // default 100
+}
+
+func ParsePageParams(req *http.Request, opts ...PageOptions) (PageParams, error) {
+	opt := PageOptions{DefaultLimit: 20, MaxLimit: 100}
+	if len(opts) > 0 {
+		if opts[0].DefaultLimit > 0 {
+			opt.DefaultLimit = opts[0].DefaultLimit
+		}
+		if opts[0].MaxLimit > 0 {
+			opt.MaxLimit = opts[0].MaxLimit
+		}
+	}
+
+	q := req.URL.Query()
+	p := PageParams{Limit: opt.DefaultLimit, Cursor: q.Get("cursor")}
+
+	intParam := func(name string, min int) (int, bool, error) {
+		s := q.Get(name)
+		if s == "" {
+			return 0, false, nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < min {
+			return 0, false, StdError(BadRequest, fmt.Sprintf("Invalid %s: %s", name, s))
+		}
+		return n, true, nil
+	}
+
+	if n, ok, err := intParam("limit", 1); err != nil {
+		return p, err
+	} else if ok {
+		p.Limit = n
+	}
+	if p.Limit > opt.MaxLimit {
+		p.Limit = opt.MaxLimit
+	}
+
+	page, hasPage, err := intParam("page", 1)
+	if err != nil {
+		return p, err
+	}
+	offset, hasOffset, err := intParam("offset", 0)
+	if err != nil {
+		return p, err
+	}
+	switch {
+	case hasOffset:
+		p.Offset = offset
+		p.Page = offset/p.Limit + 1
+	case hasPage:
+		p.Page = page
+		p.Offset = (page - 1) * p.Limit
+	default:
+		p.Page = 1
+	}
+	return p, nil
+}
+
+// RequestURL rebuilds the absolute request url, honoring X-Forwarded-Proto
+func RequestURL(req *http.Request) *neturl.URL {
+	u := *req.URL
+	if u.Host == "" {
+		u.Host = req.Host
+	}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+		if req.TLS != nil {
+			u.Scheme = "https"
+		}
+		if proto := req.Header.Get("X-Forwarded-Proto"); proto != "" {
+			u.Scheme = proto
+		}
+	}
+	return &u
+}
+
+func pageLink(base *neturl.URL, set StrMap, del ...string) string {
+	u := *base
+	q := u.Query()
+	for _, k := range del {
+		q.Del(k)
+	}
+	for k, v := range set {
+		q.Set(k, v)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// SetPage fills paging fields and links for offset based paging, with
+// itemCount items on this page; totalItems < 0 if unknown, in which case
+// a full page implies a next one; Limit <= 0 means a single page of all items
+func (d *Data) SetPage(req *http.Request, p PageParams, itemCount, totalItems int) {
+	base := RequestURL(req)
+	limit := strconv.Itoa(p.Limit)
+	byOffset := req.URL.Query().Get("offset") != ""
+
+	link := func(offset int) string {
+		if byOffset {
+			return pageLink(base, StrMap{"offset": strconv.Itoa(offset), "limit": limit}, "page")
+		}
+		return pageLink(base, StrMap{"page": strconv.Itoa(offset/p.Limit + 1), "limit": limit}, "offset")
+	}
+
+	d.CurrentItemCount = itemCount
+	d.ItemsPerPage = p.Limit
+	d.StartIndex = p.Offset + 1
+	d.PageIndex = p.Page
+	d.SelfLink = base.String()
+	d.NextLink, d.PreviousLink = "", ""
+
+	if p.Limit <= 0 {
+		if totalItems >= 0 {
+			d.TotalItems = totalItems
+			d.TotalPages = 0
+			if totalItems > 0 {
+				d.TotalPages = 1
+			}
+		}
+		return
+	}
+
+	hasNext := itemCount >= p.Limit
+	if totalItems >= 0 {
+		d.TotalItems = totalItems
+		d.TotalPages = (totalItems + p.Limit - 1) / p.Limit
+		hasNext = p.Offset+itemCount < totalItems
+	}
+	if hasNext {
+		d.NextLink = link(p.Offset + p.Limit)
+	}
+	if p.Offset > 0 {
+		prev := p.Offset - p.Limit
+		if prev < 0 {
+			prev = 0
+		}
+		d.PreviousLink = link(prev)
+	}
+}
+
+// SetCursor fills paging fields for cursor based paging; an empty
+// nextCursor means the last page
+func (d *Data) SetCursor(req *http.Request, p PageParams, itemCount int, nextCursor string) {
+	base := RequestURL(req)
+	d.CurrentItemCount = itemCount
+	d.ItemsPerPage = p.Limit
+	d.SelfLink = base.String()
+	d.NextLink = ""
+	if nextCursor != "" {
+		d.NextLink = pageLink(base, StrMap{"cursor": nextCursor, "limit": strconv.Itoa(p.Limit)}, "page", "offset")
+	}
+}
+
+// PageIterator follows nextLink across pages, until a page is empty, e.g.
+//
+//	it := NewPageIterator(url, nil)
+//	for it.Next() {
+//		var item Item
+//		if err := it.Decode(&item); err != nil { ... }
+//	}
+//	if err := it.Err(); err != nil { ... }
+type PageIterator struct {
+	url     string
+	headers StrMap
+	page    Data
+	idx     int
+	err     error
+	started bool
+}
+
+func NewPageIterator(url string, headers StrMap) *PageIterator {
+	return &PageIterator{url: url, headers: headers}
+}
+
+func (it *PageIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	it.idx++
+	for !it.started || it.idx >= len(it.page.Items) {
+		if it.started && it.page.NextLink == "" {
+			return false
+		}
+		if !it.fetch() || len(it.page.Items) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (it *PageIterator) fetch() bool {
+	url := it.url
+	if it.started {
+		next, err := neturl.Parse(it.page.NextLink)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		base, err := neturl.Parse(it.url)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		url = base.ResolveReference(next).String()
+		if url == it.url {
+			it.err = fmt.Errorf("Next link repeats %s", url)
+			return false
+		}
+		it.url = url
+	}
+
+	var msg JsonMsg
+	if err := AjaxGetUnmarshal(url, it.headers, &msg); err != nil {
+		it.err = err
+		return false
+	}
+	if msg.Error.Code > 0 || msg.Error.Message != "" {
+		it.err = msg.Error
+		return false
+	}
+	it.started = true
+	it.page = msg.Data
+	it.idx = 0
+	return true
+}
+
+// Page returns the current page, with paging fields
+func (it *PageIterator) Page() Data {
+	return it.page
+}
+
+func (it *PageIterator) Item() json.RawMessage {
+	if !it.started || it.idx >= len(it.page.Items) {
+		return nil
+	}
+	return it.page.Items[it.idx]
+}
+
+func (it *PageIterator) Decode(v interface{}) error {
+	item := it.Item()
+	if item == nil {
+		return fmt.Errorf("No current item")
+	}
+	return json.Unmarshal(item, v)
+}
+
+func (it *PageIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestParsePageParams(t *testing.T) {
+	var tests = []struct {
+		query string
+		want  PageParams
+		bad   bool
+	}{
+		{"", PageParams{Page: 1, Limit: 20}, false},
+		{"page=3&limit=10", PageParams{Page: 3, Offset: 20, Limit: 10}, false},
+		{"offset=25&limit=10", PageParams{Page: 3, Offset: 25, Limit: 10}, false},
+		{"limit=500", PageParams{Page: 1, Limit: 100}, false},
+		{"cursor=abc", PageParams{Page: 1, Limit: 20, Cursor: "abc"}, false},
+		{"limit=0", PageParams{}, true},
+		{"page=0", PageParams{}, true},
+		{"offset=-1", PageParams{}, true},
+		{"page=x", PageParams{}, true},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "http://example.com/items?"+tt.query, nil)
+		p, err := ParsePageParams(req)
+		if tt.bad {
+			if !IsBadRequest(err) {
+				t.Fatalf("%s: expected bad request, got %v", tt.query, err)
+			}
+		} else if err != nil || p != tt.want {
+			t.Fatalf("%s: expected %+v, got %+v %v", tt.query, tt.want, p, err)
+		}
+	}
+}
+
+func TestDataSetPage(t *testing.T) {
+	var tests = []struct {
+		url          string
+		p            PageParams
+		count        int
+		total        int
+		next, prev   string
+		totalPages   int
+		startIndex   int
+		pageIndex    int
+		itemsPerPage int
+	}{
+		{"/items?page=2&limit=10", PageParams{Page: 2, Offset: 10, Limit: 10}, 10, 25,
+			"http://example.com/items?limit=10&page=3", "http://example.com/items?limit=10&page=1", 3, 11, 2, 10},
+		{"/items?page=3&limit=10", PageParams{Page: 3, Offset: 20, Limit: 10}, 5, 25,
+			"", "http://example.com/items?limit=10&page=2", 3, 21, 3, 10},
+		{"/items?offset=5&limit=10", PageParams{Page: 1, Offset: 5, Limit: 10}, 10, -1,
+			"http://example.com/items?limit=10&offset=15", "http://example.com/items?limit=10&offset=0", 0, 6, 1, 10},
+		{"/items", PageParams{Page: 1, Limit: 10}, 9, -1, "", "", 0, 1, 1, 10},
+		{"/items", PageParams{Page: 1}, 7, 7, "", "", 1, 1, 1, 0},
+		{"/items", PageParams{}, 0, 0, "", "", 0, 1, 0, 0},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.url, nil)
+		req.Host = "example.com"
+		var d Data
+		d.SetPage(req, tt.p, tt.count, tt.total)
+		if d.NextLink != tt.next || d.PreviousLink != tt.prev {
+			t.Fatalf("%s: bad links %q %q", tt.url, d.NextLink, d.PreviousLink)
+		}
+		if d.TotalPages != tt.totalPages || d.StartIndex != tt.startIndex || d.PageIndex != tt.pageIndex ||
+			d.ItemsPerPage != tt.itemsPerPage || d.CurrentItemCount != tt.count || d.SelfLink != "http://example.com"+tt.url {
+			t.Fatalf("%s: bad paging %+v", tt.url, d)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/items?cursor=a&page=2", nil)
+	req.Host = "example.com"
+	var d Data
+	d.SetCursor(req, PageParams{Limit: 5}, 5, "b")
+	if d.NextLink != "http://example.com/items?cursor=b&limit=5" {
+		t.Fatalf("bad cursor link %q", d.NextLink)
+	}
+}
+
+func TestPageIterator(t *testing.T) {
+	const total = 7
+	var emptyTail, repeat bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		p, err := ParsePageParams(req, PageOptions{DefaultLimit: 3})
+		if err != nil {
+			WriteJsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var msg JsonMsg
+		for i := p.Offset; i < total && i < p.Offset+p.Limit; i++ {
+			msg.AddItem(Map{"n": i})
+		}
+		msg.SetPage(req, p, len(msg.Items), total)
+		switch {
+		case repeat:
+			msg.NextLink = req.URL.String()
+		case emptyTail: // always linking on, even from empty pages
+			msg.NextLink = "/items?page=" + strconv.Itoa(p.Page+1)
+		}
+		buf, _ := json.Marshal(msg)
+		w.Header().Set("Content-Type", ctAppJson)
+		w.Write(buf)
+	}))
+	defer srv.Close()
+
+	collect := func() ([]int, *PageIterator) {
+		it := NewPageIterator(srv.URL+"/items", nil)
+		var ns []int
+		for it.Next() {
+			var item struct{ N int }
+			if err := it.Decode(&item); err != nil {
+				t.Fatal(err)
+			}
+			ns = append(ns, item.N)
+		}
+		return ns, it
+	}
+
+	ns, it := collect()
+	if len(ns) != total || ns[total-1] != total-1 || it.Err() != nil || it.Page().PageIndex != 3 {
+		t.Fatalf("bad items %v %v", ns, it.Err())
+	}
+	if it.Item() != nil || it.Decode(&struct{}{}) == nil {
+		t.Fatal("expected no current item")
+	}
+
+	emptyTail = true
+	if ns, it = collect(); len(ns) != total || it.Err() != nil {
+		t.Fatalf("expected to stop on an empty page, got %v %v", ns, it.Err())
+	}
+
+	emptyTail, repeat = false, true
+	if ns, it = collect(); len(ns) != 3 || it.Err() == nil {
+		t.Fatalf("expected a repeated link error, got %v %v", ns, it.Err())
+	}
+
+	it = NewPageIterator(srv.URL+"/items?limit=x", nil)
+	if it.Next() || !IsBadRequest(it.Err()) {
+		t.Fatalf("expected bad request, got %v", it.Err())
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// REF: https://www.rfc-editor.org/rfc/rfc7807
+
+const ctProblemJson = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object; Extensions are serialized
+// as top-level members
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Extensions Map
+}
+
+var problemMembers = []string{"type", "title", "status", "detail", "instance"}
+
+func (p Problem) MarshalJSON() ([]byte, error) {
+	m := Map{}
+	for k, v := range p.Extensions {
+		if !ContainsString(problemMembers, k) {
+			m[k] = v
+		}
+	}
+	if p.Type != "" {
+		m["type"] = p.Type
+	}
+	if p.Title != "" {
+		m["title"] = p.Title
+	}
+	if p.Status != 0 {
+		m["status"] = p.Status
+	}
+	if p.Detail != "" {
+		m["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		m["instance"] = p.Instance
+	}
+	return json.Marshal(m)
+}
+
+func (p *Problem) UnmarshalJSON(data []byte) error {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	*p = Problem{}
+	for k, raw := range m {
+		var err error
+		switch k {
+		case "type":
+			err = json.Unmarshal(raw, &p.Type)
+		case "title":
+			err = json.Unmarshal(raw, &p.Title)
+		case "status":
+			err = json.Unmarshal(raw, &p.Status)
+		case "detail":
+			err = json.Unmarshal(raw, &p.Detail)
+		case "instance":
+			err = json.Unmarshal(raw, &p.Instance)
+		default:
+			var v interface{}
+			if err = json.Unmarshal(raw, &v); err == nil {
+				if p.Extensions == nil {
+					p.Extensions = Map{}
+				}
+				p.Extensions[k] = v
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("Invalid problem member %s: %w", k, err)
+		}
+	}
+	return nil
+}
+
+// ProblemFromError converts err, with ErrorItems kept in the "errors"
+// extension; errors other than Error become InternalServerError, and the
+// detail is the message of the outermost Error, without its causes
+func ProblemFromError(err error) Problem {
+	code := ErrorCode(err, InternalServerError)
+	if code == 0 {
+		code = InternalServerError
+	}
+	p := Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(code),
+		Status: code,
+		Detail: errorMessage(err, code),
+	}
+	if items := errorItems(err); len(items) > 0 {
+		p.Extensions = Map{"errors": items}
+	}
+	if details := ErrorDetailsOf(err).exposed(); len(details) > 0 {
+		if p.Extensions == nil {
+			p.Extensions = Map{}
+		}
+		p.Extensions["details"] = details
+	}
+	return p
+}
+
+// errorMessage returns the message of the first Error in the chain having
+// one, or the status text of code; the causes, e.g. from drivers, are left
+// out of responses and are for logging only
+func errorMessage(err error, code int) string {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if e, ok := err.(Error); ok && e.Message != "" {
+			return e.Message
+		}
+	}
+	if msg := http.StatusText(code); msg != "" {
+		return msg
+	}
+	return "Error " + strconv.Itoa(code)
+}
+
+// errorItems returns the items of the first Error in the chain having any
+func errorItems(err error) []ErrorItem {
+	return itemsError(err).Errors
+}
+
+// itemsError returns the first Error in the chain having items, if any
+func itemsError(err error) Error {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if e, ok := err.(Error); ok && len(e.Errors) > 0 {
+			return e
+		}
+	}
+	return Error{}
+}
+
+// ToError converts back to Error, recovering ErrorItems and ErrorDetails
+// from the "errors" and "details" extensions if possible
+func (p Problem) ToError() Error {
+	msg := p.Detail
+	if msg == "" {
+		msg = p.Title
+	}
+	e := CodeError(p.Status, msg)
+	if p.Status == 0 {
+		e.Code = InternalServerError
+	}
+	if items, ok := p.Extensions["errors"]; ok {
+		if buf, err := json.Marshal(items); err == nil {
+			json.Unmarshal(buf, &e.Errors)
+		}
+	}
+	if details, ok := p.Extensions["details"]; ok {
+		if buf, err := json.Marshal(details); err == nil {
+			json.Unmarshal(buf, &e.Details)
+		}
+	}
+	return e
+}
+
+func WriteProblem(w http.ResponseWriter, p Problem) {
+	buf, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		WriteJsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	status := p.Status
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", ctProblemJson)
+	w.WriteHeader(status)
+	w.Write(buf)
+}
+
+// WriteError replies with problem+json if preferred by the Accept header of
+// req, otherwise with a JsonMsg error; status defaults to InternalServerError,
+// RetryInfo details set Retry-After, and messages are localized per
+// Accept-Language if ErrorMessages is set; only the message of the outermost
+// Error is sent, not the text of its causes
+func WriteError(w http.ResponseWriter, req *http.Request, err error) {
+	code := ErrorCode(err, InternalServerError)
+	if code == 0 {
+		code = InternalServerError
+	}
+	items := itemsError(err)
+	e := StdError(code, errorMessage(err, code), items.Errors...).WithItemParams(items.params...)
+	e.Details = ErrorDetailsOf(err).exposed() // nil to omit "details"
+
+	if catalog := ErrorMessages; catalog != nil && req != nil {
+		lang := catalog.Match(req.Header.Get("Accept-Language"))
+		e = catalog.Localize(e, lang)
+		if lang != "" {
+			w.Header().Set("Content-Language", lang)
+		}
+	}
+	if delay, ok := RetryDelay(err); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int((delay+time.Second-1)/time.Second)))
+	}
+	if req != nil && PrefersProblemJson(req) {
+		WriteProblem(w, ProblemFromError(e))
+		return
+	}
+
+	buf, _ := json.MarshalIndent(JsonMsg{Error: e}, "", "  ")
+	w.Header().Set("Content-Type", ctAppJson)
+	w.WriteHeader(code)
+	w.Write(buf)
+}
+
+// PrefersProblemJson checks if application/problem+json has a higher quality
+// than application/json in the Accept header
+func PrefersProblemJson(req *http.Request) bool {
+	problemQ, jsonQ := -1.0, -1.0
+	for _, accept := range req.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			q := 1.0
+			if s, ok := params["q"]; ok {
+				if f, err := strconv.ParseFloat(s, 64); err == nil {
+					q = f
+				}
+			}
+			switch mt {
+			case ctProblemJson:
+				if q > problemQ {
+					problemQ = q
+				}
+			case ctAppJson:
+				if q > jsonQ {
+					jsonQ = q
+				}
+			}
+		}
+	}
+	return problemQ > 0 && problemQ > jsonQ
+}
+
+// ResponseError converts an error response, reading either problem+json or a
+// JsonMsg error from its body
+func ResponseError(res *http.Response) Error {
+	fallback := CodeError(res.StatusCode, fmt.Sprintf("StatusCode %d", res.StatusCode))
+	if res.Body == nil {
+		return fallback
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil || len(buf) == 0 {
+		return fallback
+	}
+
+	ct, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if ct == ctProblemJson {
+		var p Problem
+		if json.Unmarshal(buf, &p) != nil {
+			return fallback
+		}
+		if p.Status == 0 {
+			p.Status = res.StatusCode
+		}
+		return p.ToError()
+	}
+
+	var msg JsonMsg
+	if json.Unmarshal(buf, &msg) != nil || (msg.Error.Message == "" && msg.Error.Code == 0) {
+		return fallback
+	}
+	e := msg.Error
+	if e.Code == 0 {
+		e.Code = res.StatusCode
+	}
+	return e
+}
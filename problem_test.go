@@ -0,0 +1,84 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProblemJson(t *testing.T) {
+	p := Problem{Type: "https://example.com/out-of-credit", Status: Forbidden, Detail: "balance 30",
+		Extensions: Map{"balance": 30, "status": "ignored"}}
+	buf, err := json.Marshal(p)
+	if err != nil || !JsonStrEqual(string(buf), `{"type":"https://example.com/out-of-credit","status":403,"detail":"balance 30","balance":30}`) {
+		t.Fatalf("bad marshal %s %v", buf, err)
+	}
+	var back Problem
+	if err := json.Unmarshal(buf, &back); err != nil || back.Status != Forbidden || back.Extensions["balance"] != 30.0 {
+		t.Fatalf("bad unmarshal %+v %v", back, err)
+	}
+
+	e := StdError(UnprocessableEntity, "invalid user", ErrorItem{Message: "name required", Reason: "required"})
+	back = ProblemFromError(Wrap(e, 0, "saving"))
+	if back.Title != "Unprocessable Entity" || back.Detail != "saving" {
+		t.Fatalf("bad conversion %+v", back)
+	}
+	if e = back.ToError(); e.Code != UnprocessableEntity || len(e.Errors) != 1 || e.Errors[0].Reason != "required" {
+		t.Fatalf("bad round-trip %+v", e)
+	}
+}
+
+func TestWriteErrorHidesCauses(t *testing.T) {
+	cause := errors.New("pq: password authentication failed for user app")
+	for _, tt := range []struct {
+		err  error
+		want string
+	}{
+		{Wrap(cause, 0, "Cannot load user"), "Cannot load user"},
+		{fmt.Errorf("loading: %w", Wrap(cause, NotFound, "")), "Not Found"},
+		{cause, "Internal Server Error"},
+	} {
+		for _, accept := range []string{ctAppJson, ctProblemJson} {
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept", accept)
+			w := NewResponseWriter()
+			WriteError(w, req, tt.err)
+			if body := w.String(); strings.Contains(body, "pq:") || strings.Contains(body, "loading") || !strings.Contains(body, tt.want) {
+				t.Fatalf("%v: expected only %q in %s", tt.err, tt.want, body)
+			}
+		}
+	}
+	if p := ProblemFromError(Wrap(cause, 0, "Cannot load user")); p.Detail != "Cannot load user" || p.Status != 500 {
+		t.Fatalf("bad problem %+v", p)
+	}
+}
+
+func TestWriteErrorNegotiation(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		WriteError(w, req, ConflictError("already there"))
+	}))
+	defer srv.Close()
+
+	for _, accept := range []string{"", "application/json", "application/problem+json", "application/json;q=0.5, application/problem+json"} {
+		res, err := HttpDo(http.MethodGet, srv.URL, "", nil, StrMap{"Accept": accept})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantProblem := accept == "application/problem+json" || accept == "application/json;q=0.5, application/problem+json"
+		if ct := res.Header.Get("Content-Type"); (ct == ctProblemJson) != wantProblem {
+			t.Fatalf("bad content type %s for %q", ct, accept)
+		}
+		var m Map
+		err = UnmarshalResponse(res, &m)
+		res.Body.Close()
+		if !IsConflict(err) || err.Error() != "already there" {
+			t.Fatalf("bad client error %v for %q", err, accept)
+		}
+	}
+}
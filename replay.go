@@ -0,0 +1,300 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// record/replay of http calls into json fixture files, mostly for tests, e.g.
+//
+//	rt, err := NewReplayTransport("testdata/items.json", ReplayOnly, nil)
+//	HttpClient = &http.Client{Transport: rt}
+
+type ReplayMode int
+
+const (
+	ReplayOnly        ReplayMode = iota // fail on calls not in fixtures
+	ReplayRecord                        // call through and record into fixtures
+	ReplayPassthrough                   // call through, no fixtures involved
+)
+
+// ReplayBase64 is the BodyEncoding of bodies that are not valid UTF-8
+const ReplayBase64 = "base64"
+
+type ReplayRequest struct {
+	Method       string      `json:"method"`
+	URL          string      `json:"url"`
+	Header       http.Header `json:"header,omitempty"`
+	Body         string      `json:"body,omitempty"`
+	BodyEncoding string      `json:"bodyEncoding,omitempty"`
+}
+
+type ReplayResponse struct {
+	StatusCode   int         `json:"statusCode"`
+	Header       http.Header `json:"header,omitempty"`
+	Body         string      `json:"body,omitempty"`
+	BodyEncoding string      `json:"bodyEncoding,omitempty"`
+}
+
+func encodeReplayBody(body []byte) (string, string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), ReplayBase64
+}
+
+func decodeReplayBody(body, encoding string) []byte {
+	if encoding == ReplayBase64 {
+		if buf, err := base64.StdEncoding.DecodeString(body); err == nil {
+			return buf
+		}
+	}
+	return []byte(body)
+}
+
+type ReplayInteraction struct {
+	Request  ReplayRequest  `json:"request"`
+	Response ReplayResponse `json:"response"`
+}
+
+// ReplayTransport matches requests on method, URL, MatchHeaders and body,
+// with JSON bodies compared by JsonEqual. Recorded interactions are replayed
+// in order when the same request is made more than once.
+type ReplayTransport struct {
+	Transport    http.RoundTripper
+	Mode         ReplayMode
+	Path         string
+	MatchHeaders []string
+
+	mu           sync.Mutex
+	interactions []ReplayInteraction
+	used         []bool
+	saveErr      error
+}
+
+// NewReplayTransport loads fixtures from path unless recording, which starts
+// afresh and saves after each recorded call
+func NewReplayTransport(path string, mode ReplayMode, transport http.RoundTripper) (*ReplayTransport, error) {
+	t := &ReplayTransport{Transport: transport, Mode: mode, Path: path}
+	if mode == ReplayOnly {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(buf, &t.interactions); err != nil {
+			return nil, fmt.Errorf("Bad fixture file %s: %v", path, err)
+		}
+		t.used = make([]bool, len(t.interactions))
+	}
+	return t, nil
+}
+
+func (t *ReplayTransport) Interactions() []ReplayInteraction {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]ReplayInteraction(nil), t.interactions...)
+}
+
+// Unused returns interactions never replayed
+func (t *ReplayTransport) Unused() []ReplayInteraction {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var ret []ReplayInteraction
+	for i, used := range t.used {
+		if !used {
+			ret = append(ret, t.interactions[i])
+		}
+	}
+	return ret
+}
+
+// Err returns the last error saving recorded interactions, which does not
+// fail the recorded calls themselves
+func (t *ReplayTransport) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.saveErr
+}
+
+func (t *ReplayTransport) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return http.DefaultTransport
+}
+
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch t.Mode {
+	case ReplayPassthrough:
+		return t.transport().RoundTrip(req)
+	case ReplayRecord:
+		return t.record(req)
+	}
+
+	rr, _, err := t.replayRequest(req, false)
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	idx := -1
+	for i := range t.interactions {
+		if t.matches(&t.interactions[i].Request, rr) {
+			if !t.used[i] {
+				idx = i
+				break
+			} else if idx < 0 {
+				idx = i
+			}
+		}
+	}
+	if idx < 0 {
+		return nil, fmt.Errorf("No recorded response for %s %s", req.Method, req.URL)
+	}
+	t.used[idx] = true
+	return t.interactions[idx].Response.response(req), nil
+}
+
+func (t *ReplayTransport) record(req *http.Request) (*http.Response, error) {
+	rr, out, err := t.replayRequest(req, true)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := t.transport().RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ReadResponseBody(res)
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	resBody, resEnc := encodeReplayBody(body)
+	t.interactions = append(t.interactions, ReplayInteraction{
+		Request: *rr,
+		Response: ReplayResponse{
+			StatusCode:   res.StatusCode,
+			Header:       res.Header.Clone(),
+			Body:         resBody,
+			BodyEncoding: resEnc,
+		},
+	})
+	t.used = append(t.used, true)
+	t.saveErr = t.save()
+	return res, nil
+}
+
+func (t *ReplayTransport) Save() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.save()
+}
+
+func (t *ReplayTransport) save() error {
+	buf, err := json.MarshalIndent(t.interactions, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(t.Path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(t.Path, buf, 0644)
+}
+
+// replayRequest reads the request body from GetBody if possible, leaving req
+// intact, and returns the request to send on, a clone if the body was consumed;
+// keeps most headers for recording
+func (t *ReplayTransport) replayRequest(req *http.Request, allHeaders bool) (*ReplayRequest, *http.Request, error) {
+	rr := &ReplayRequest{Method: req.Method, URL: req.URL.String()}
+	out := req
+	if req.Body != nil && req.Body != http.NoBody {
+		var body []byte
+		var err error
+		if req.GetBody != nil {
+			var rc io.ReadCloser
+			if rc, err = req.GetBody(); err == nil {
+				body, err = ioutil.ReadAll(rc)
+				rc.Close()
+			}
+		} else {
+			body, err = ReadRequestBody(req)
+			out = req.Clone(req.Context())
+			out.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		rr.Body, rr.BodyEncoding = encodeReplayBody(body)
+	}
+	if allHeaders {
+		// keep credentials out of fixtures unless needed for matching
+		rr.Header = req.Header.Clone()
+		for _, h := range []string{"Authorization", "Cookie"} {
+			if !ContainsString(t.MatchHeaders, h) {
+				rr.Header.Del(h)
+			}
+		}
+	} else {
+		rr.Header = http.Header{}
+		for _, h := range t.MatchHeaders {
+			if v := req.Header.Values(h); len(v) > 0 {
+				rr.Header[http.CanonicalHeaderKey(h)] = v
+			}
+		}
+	}
+	return rr, out, nil
+}
+
+func (t *ReplayTransport) matches(recorded, rr *ReplayRequest) bool {
+	if recorded.Method != rr.Method || recorded.URL != rr.URL {
+		return false
+	}
+	for _, h := range t.MatchHeaders {
+		if recorded.Header.Get(h) != rr.Header.Get(h) {
+			return false
+		}
+	}
+	if recorded.Body == rr.Body && recorded.BodyEncoding == rr.BodyEncoding {
+		return true
+	}
+	return recorded.BodyEncoding == "" && rr.BodyEncoding == "" && JsonStrEqual(recorded.Body, rr.Body)
+}
+
+func (r *ReplayResponse) response(req *http.Request) *http.Response {
+	header := r.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	body := decodeReplayBody(r.Body, r.BodyEncoding)
+	return &http.Response{
+		Status:        strconv.Itoa(r.StatusCode) + " " + http.StatusText(r.StatusCode),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReplayTransport(t *testing.T) {
+	binary := []byte{0xff, 0x00, 0xfe, 'x'}
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		body, _ := ioutil.ReadAll(req.Body)
+		switch req.URL.Path {
+		case "/echo":
+			w.Write(body)
+		case "/binary":
+			w.Write(binary)
+		}
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "fixtures", "calls.json")
+	rec, err := NewReplayTransport(path, ReplayRecord, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: rec}
+
+	call := func(method, path, body string) ([]byte, error) {
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		orig := req.Body
+		res, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if req.Body != orig {
+			t.Fatal("the request body should not be replaced")
+		}
+		return ReadResponseBody(res)
+	}
+
+	if got, err := call("POST", "/echo", `{"a": 1}`); err != nil || string(got) != `{"a": 1}` {
+		t.Fatalf("bad recorded echo %q %v", got, err)
+	}
+	if got, err := call("POST", "/echo", string(binary)); err != nil || !bytes.Equal(got, binary) {
+		t.Fatalf("bad recorded binary echo %q %v", got, err)
+	}
+	if got, err := call("GET", "/binary", ""); err != nil || !bytes.Equal(got, binary) {
+		t.Fatalf("bad recorded binary %q %v", got, err)
+	}
+	if rec.Err() != nil || calls != 3 {
+		t.Fatalf("bad recording %v after %d calls", rec.Err(), calls)
+	}
+	if it := rec.Interactions(); it[1].Request.BodyEncoding != ReplayBase64 || it[2].Response.BodyEncoding != ReplayBase64 {
+		t.Fatalf("binary bodies should be base64 encoded: %+v", it)
+	}
+
+	// replay from the saved file, matching JSON bodies by value
+	rep, err := NewReplayTransport(path, ReplayOnly, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Transport = rep
+	if got, err := call("POST", "/echo", `{"a":1}`); err != nil || string(got) != `{"a": 1}` {
+		t.Fatalf("bad replayed echo %q %v", got, err)
+	}
+	if got, err := call("GET", "/binary", ""); err != nil || !bytes.Equal(got, binary) {
+		t.Fatalf("bad replayed binary %q %v", got, err)
+	}
+	if len(rep.Unused()) != 1 {
+		t.Fatalf("expected 1 unused interaction, got %v", rep.Unused())
+	}
+	if _, err := call("POST", "/echo", `{"a": 2}`); err == nil {
+		t.Fatal("unrecorded calls should fail")
+	}
+	if calls != 3 {
+		t.Fatal("replay should not call the server")
+	}
+
+	// failing to save does not fail the call
+	bad, _ := NewReplayTransport(filepath.Join(path, "not-a-dir", "x.json"), ReplayRecord, nil)
+	client.Transport = bad
+	if got, err := call("GET", "/binary", ""); err != nil || !bytes.Equal(got, binary) {
+		t.Fatalf("bad response %q %v", got, err)
+	}
+	if bad.Err() == nil {
+		t.Fatal("expected save error")
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type Middleware func(http.Handler) http.Handler
+
+func ChainMiddleware(h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// Router matches methods and paths like "/users/{id}" or "/files/{path...}"
+// ("*" is short for "{*...}"), storing path parameters in request context.
+// A group shares routes with its parent; its middlewares apply to routes
+// registered after Use.
+type Router struct {
+	root        *Router
+	prefix      string
+	middlewares []Middleware
+
+	routes           []*route
+	NotFound         http.Handler
+	MethodNotAllowed http.Handler
+}
+
+type route struct {
+	method   string
+	pattern  string
+	segments []routeSegment
+	handler  http.Handler
+}
+
+const (
+	segStatic = iota
+	segParam
+	segWildcard
+)
+
+type routeSegment struct {
+	kind int
+	name string // static text or param name
+}
+
+func NewRouter() *Router {
+	r := &Router{}
+	r.root = r
+	return r
+}
+
+func (r *Router) Use(mws ...Middleware) {
+	r.middlewares = append(r.middlewares, mws...)
+}
+
+// Group creates a sub-router under prefix, inheriting the group middlewares
+// registered so far; fn, if not nil, is called with the group
+func (r *Router) Group(prefix string, fn func(g *Router)) *Router {
+	g := &Router{
+		root:   r.root,
+		prefix: joinRoutePath(r.prefix, prefix),
+	}
+	if r != r.root {
+		g.middlewares = append([]Middleware(nil), r.middlewares...)
+	}
+	if fn != nil {
+		fn(g)
+	}
+	return g
+}
+
+func (r *Router) Handle(method, pattern string, h http.Handler) {
+	full := joinRoutePath(r.prefix, pattern)
+	if r != r.root {
+		h = ChainMiddleware(h, r.middlewares...)
+	}
+	r.root.routes = append(r.root.routes, &route{
+		method:   strings.ToUpper(method),
+		pattern:  full,
+		segments: parseRoutePattern(full),
+		handler:  h,
+	})
+}
+
+func (r *Router) HandleFunc(method, pattern string, f http.HandlerFunc) {
+	r.Handle(method, pattern, f)
+}
+
+func (r *Router) Get(pattern string, f http.HandlerFunc) {
+	r.Handle(http.MethodGet, pattern, f)
+}
+func (r *Router) Post(pattern string, f http.HandlerFunc) {
+	r.Handle(http.MethodPost, pattern, f)
+}
+func (r *Router) Put(pattern string, f http.HandlerFunc) {
+	r.Handle(http.MethodPut, pattern, f)
+}
+func (r *Router) Patch(pattern string, f http.HandlerFunc) {
+	r.Handle(http.MethodPatch, pattern, f)
+}
+func (r *Router) Delete(pattern string, f http.HandlerFunc) {
+	r.Handle(http.MethodDelete, pattern, f)
+}
+
+type RouteInfo struct {
+	Method  string
+	Pattern string
+}
+
+// Routes lists all registered routes, in registration order
+func (r *Router) Routes() []RouteInfo {
+	ret := make([]RouteInfo, len(r.root.routes))
+	for i, rt := range r.root.routes {
+		ret[i] = RouteInfo{rt.method, rt.pattern}
+	}
+	return ret
+}
+
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	root := r.root
+	ChainMiddleware(http.HandlerFunc(root.dispatch), root.middlewares...).ServeHTTP(w, req)
+}
+
+func (r *Router) dispatch(w http.ResponseWriter, req *http.Request) {
+	parts := splitRoutePath(req.URL.Path)
+
+	var best, bestAny *route
+	var bestParams, anyParams StrMap
+	allowed := map[string]bool{}
+	for _, rt := range r.routes {
+		params, ok := rt.match(parts)
+		if !ok {
+			continue
+		}
+		allowed[rt.method] = true
+		if rt.method == http.MethodGet {
+			allowed[http.MethodHead] = true // served by GET routes
+		}
+		if bestAny == nil || rt.before(bestAny) {
+			bestAny, anyParams = rt, params
+		}
+		if rt.method == req.Method || (req.Method == http.MethodHead && rt.method == http.MethodGet) {
+			if best == nil || rt.before(best) || (rt.method == req.Method && best.method != req.Method) {
+				best, bestParams = rt, params
+			}
+		}
+	}
+
+	switch {
+	case best != nil:
+		ctx := ContextWithPathParams(req.Context(), bestParams)
+		best.handler.ServeHTTP(w, req.WithContext(ctx))
+	case bestAny != nil:
+		methods := make([]string, 0, len(allowed))
+		for m := range allowed {
+			methods = append(methods, m)
+		}
+		sort.Strings(methods)
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		if r.MethodNotAllowed != nil {
+			ctx := ContextWithPathParams(req.Context(), anyParams)
+			r.MethodNotAllowed.ServeHTTP(w, req.WithContext(ctx))
+		} else {
+			WriteJsonError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+		}
+	case r.NotFound != nil:
+		r.NotFound.ServeHTTP(w, req)
+	default:
+		WriteJsonError(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+func WriteJsonError(w http.ResponseWriter, statusCode int, msg string) {
+	w.Header().Set("Content-Type", ctAppJson)
+	w.WriteHeader(statusCode)
+	w.Write(SimpleJsonError(msg, statusCode))
+}
+
+func (rt *route) match(parts []string) (StrMap, bool) {
+	params := StrMap{}
+	for i, seg := range rt.segments {
+		switch seg.kind {
+		case segWildcard:
+			params[seg.name] = strings.Join(parts[i:], "/")
+			return params, true
+		case segParam:
+			if i >= len(parts) || parts[i] == "" {
+				return nil, false
+			}
+			params[seg.name] = parts[i]
+		default:
+			if i >= len(parts) || parts[i] != seg.name {
+				return nil, false
+			}
+		}
+	}
+	if len(parts) != len(rt.segments) {
+		return nil, false
+	}
+	return params, true
+}
+
+// static segments win over params, params over wildcards
+func (rt *route) before(other *route) bool {
+	for i, seg := range rt.segments {
+		if i >= len(other.segments) {
+			return false
+		}
+		if k := other.segments[i].kind; seg.kind != k {
+			return seg.kind < k
+		}
+	}
+	return len(rt.segments) > len(other.segments)
+}
+
+func parseRoutePattern(pattern string) []routeSegment {
+	parts := splitRoutePath(pattern)
+	segs := make([]routeSegment, 0, len(parts))
+	for _, p := range parts {
+		switch {
+		case p == "*":
+			segs = append(segs, routeSegment{segWildcard, "*"})
+		case strings.HasPrefix(p, "{") && strings.HasSuffix(p, "...}"):
+			segs = append(segs, routeSegment{segWildcard, p[1 : len(p)-4]})
+		case strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}"):
+			segs = append(segs, routeSegment{segParam, p[1 : len(p)-1]})
+		default:
+			segs = append(segs, routeSegment{segStatic, p})
+		}
+	}
+	return segs
+}
+
+func splitRoutePath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+func joinRoutePath(prefix, path string) string {
+	return "/" + strings.Trim(strings.TrimRight(prefix, "/")+"/"+strings.TrimLeft(path, "/"), "/")
+}
+
+// path params
+
+type pathParamsKey struct{}
+
+func ContextWithPathParams(ctx context.Context, params StrMap) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, pathParamsKey{}, params)
+}
+
+func PathParamsFromContext(ctx context.Context) StrMap {
+	params, _ := ctx.Value(pathParamsKey{}).(StrMap)
+	return params
+}
+
+func PathParams(req *http.Request) StrMap {
+	return PathParamsFromContext(req.Context())
+}
+
+func PathParam(req *http.Request, name string) string {
+	return PathParams(req)[name]
+}
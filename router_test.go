@@ -0,0 +1,72 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	echo := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(name + ":" + ParamsString(PathParams(req))))
+		}
+	}
+
+	r := NewRouter()
+	r.Get("/", echo("root"))
+	r.Get("/users/{id}", echo("user"))
+	r.Get("/users/me", echo("me"))
+	r.Delete("/users/{id}", echo("delete"))
+	r.Get("/files/{path...}", echo("files"))
+	r.Group("/api", func(g *Router) {
+		g.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Write([]byte("api-"))
+				next.ServeHTTP(w, req)
+			})
+		})
+		g.Post("/items/{id}", echo("item"))
+	})
+
+	var tests = []struct {
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{"GET", "/", 200, "root:"},
+		{"GET", "/users/42", 200, "user:id=42"},
+		{"GET", "/users/42/", 200, "user:id=42"},
+		{"GET", "/users/me", 200, "me:"},
+		{"DELETE", "/users/42", 200, "delete:id=42"},
+		{"HEAD", "/users/42", 200, "user:id=42"},
+		{"GET", "/files/a/b/c.txt", 200, "files:path=a/b/c.txt"},
+		{"POST", "/api/items/7", 200, "api-item:id=7"},
+		{"PUT", "/users/42", 405, "DELETE, GET, HEAD"},
+		{"GET", "/nothing", 404, ""},
+		{"GET", "/api/items/7", 405, "POST"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.path, nil)
+		w := NewResponseWriter()
+		r.ServeHTTP(w, req)
+		if w.StatusCode() != tt.status {
+			t.Fatalf("%s %s: expected %d, got %d", tt.method, tt.path, tt.status, w.StatusCode())
+		}
+		if tt.status == 200 && w.String() != tt.body {
+			t.Fatalf("%s %s: expected %q, got %q", tt.method, tt.path, tt.body, w.String())
+		}
+		if tt.status == 405 && w.Header().Get("Allow") != tt.body {
+			t.Fatalf("%s %s: expected Allow %q, got %q", tt.method, tt.path, tt.body, w.Header().Get("Allow"))
+		}
+		if tt.status >= 400 {
+			var msg JsonMsg
+			if err := w.Unmarshal(&msg, true); err != nil || msg.Error.Code != tt.status {
+				t.Fatalf("%s %s: bad error body %s", tt.method, tt.path, w.String())
+			}
+		}
+	}
+}
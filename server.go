@@ -0,0 +1,183 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+type ServerOptions struct {
+	Addr     string // default ":8080"
+	CertFile string // TLS if both CertFile and KeyFile given
+	KeyFile  string
+
+	ShutdownDelay     time.Duration // keep serving while not ready, for load balancers to notice
+	DrainTimeout      time.Duration // default 15s
+	ReadHeaderTimeout time.Duration // default 10s
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	LivePath  string       // default "/healthz", "-" to disable
+	ReadyPath string       // default "/readyz", "-" to disable
+	ReadyFunc func() error // extra readiness check, e.g. for dependencies
+
+	Signals    []os.Signal // default SIGINT and SIGTERM
+	OnShutdown func()      // called when shutdown begins
+}
+
+func (opts ServerOptions) withDefaults() ServerOptions {
+	if opts.Addr == "" {
+		opts.Addr = ":8080"
+	}
+	if opts.DrainTimeout <= 0 {
+		opts.DrainTimeout = 15 * time.Second
+	}
+	if opts.ReadHeaderTimeout <= 0 {
+		opts.ReadHeaderTimeout = 10 * time.Second
+	}
+	if opts.LivePath == "" {
+		opts.LivePath = "/healthz"
+	}
+	if opts.ReadyPath == "" {
+		opts.ReadyPath = "/readyz"
+	}
+	if len(opts.Signals) == 0 {
+		opts.Signals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+	return opts
+}
+
+// Server runs an http.Handler until the context is done or a signal arrives,
+// then reports not ready, waits for ShutdownDelay and drains connections
+// within DrainTimeout
+type Server struct {
+	opts    ServerOptions
+	handler http.Handler
+	ready   int32
+	addr    atomic.Value
+}
+
+func NewServer(handler http.Handler, opts ServerOptions) *Server {
+	return &Server{opts: opts.withDefaults(), handler: handler}
+}
+
+// RunServer is NewServer(...).Run(ctx) for a typical main()
+func RunServer(ctx context.Context, handler http.Handler, opts ServerOptions) error {
+	return NewServer(handler, opts).Run(ctx)
+}
+
+func (s *Server) SetReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&s.ready, v)
+}
+
+func (s *Server) IsReady() bool {
+	return atomic.LoadInt32(&s.ready) == 1
+}
+
+// Addr returns the listening address once running, e.g. for ":0"
+func (s *Server) Addr() string {
+	addr, _ := s.addr.Load().(string)
+	return addr
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case s.opts.LivePath:
+		w.Header().Set("Content-Type", ctAppJson)
+		w.Write(SimpleJsonData(StrMap{"status": "ok"}))
+		return
+	case s.opts.ReadyPath:
+		if !s.IsReady() {
+			WriteJsonError(w, http.StatusServiceUnavailable, "Not ready")
+			return
+		}
+		if s.opts.ReadyFunc != nil {
+			if err := s.opts.ReadyFunc(); err != nil {
+				WriteJsonError(w, http.StatusServiceUnavailable, err.Error())
+				return
+			}
+		}
+		w.Header().Set("Content-Type", ctAppJson)
+		w.Write(SimpleJsonData(StrMap{"status": "ready"}))
+		return
+	}
+	s.handler.ServeHTTP(w, req)
+}
+
+func (s *Server) Run(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, stop := signal.NotifyContext(ctx, s.opts.Signals...)
+	defer stop()
+
+	ln, err := net.Listen("tcp", s.opts.Addr)
+	if err != nil {
+		return err
+	}
+	s.addr.Store(ln.Addr().String())
+
+	srv := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: s.opts.ReadHeaderTimeout,
+		ReadTimeout:       s.opts.ReadTimeout,
+		WriteTimeout:      s.opts.WriteTimeout,
+		IdleTimeout:       s.opts.IdleTimeout,
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		if s.opts.CertFile != "" && s.opts.KeyFile != "" {
+			errc <- srv.ServeTLS(ln, s.opts.CertFile, s.opts.KeyFile)
+		} else {
+			errc <- srv.Serve(ln)
+		}
+	}()
+	s.SetReady(true)
+
+	select {
+	case err := <-errc:
+		s.SetReady(false)
+		return err
+	case <-ctx.Done():
+	}
+	stop() // a second signal terminates as usual
+
+	s.SetReady(false)
+	if s.opts.OnShutdown != nil {
+		s.opts.OnShutdown()
+	}
+	if s.opts.ShutdownDelay > 0 {
+		timer := time.NewTimer(s.opts.ShutdownDelay)
+		defer timer.Stop()
+		select {
+		case err := <-errc:
+			return err
+		case <-timer.C:
+		}
+	}
+
+	sctx, cancel := context.WithTimeout(context.Background(), s.opts.DrainTimeout)
+	defer cancel()
+	err = srv.Shutdown(sctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		srv.Close() // drop connections still active after DrainTimeout
+	}
+	if serr := <-errc; serr != nil && !errors.Is(serr, http.ErrServerClosed) && err == nil {
+		err = serr
+	}
+	return err
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServerShutdownDelay(t *testing.T) {
+	shutdown := make(chan struct{})
+	srv := NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	}), ServerOptions{
+		Addr:          "127.0.0.1:0",
+		ShutdownDelay: 300 * time.Millisecond,
+		OnShutdown:    func() { close(shutdown) },
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Run(ctx) }()
+
+	for i := 0; srv.Addr() == "" || !srv.IsReady(); i++ {
+		if i > 100 {
+			t.Fatal("server not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	base := "http://" + srv.Addr()
+	// idle keep-alive connections that never sent a request hold up Shutdown
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	status := func(path string) int {
+		res, err := client.Get(base + path)
+		if err != nil {
+			return 0
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if status("/healthz") != 200 || status("/readyz") != 200 || status("/") != 200 {
+		t.Fatal("should be live and ready")
+	}
+
+	cancel()
+	<-shutdown
+	// still serving during the delay, but not ready
+	if status("/readyz") != 503 || status("/") != 200 || status("/healthz") != 200 {
+		t.Fatal("should serve but not be ready during the shutdown delay")
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop")
+	}
+	if status("/") != 0 {
+		t.Fatal("server should be closed")
+	}
+}
+
+func TestServerDrainTimeout(t *testing.T) {
+	started, aborted := make(chan struct{}), make(chan struct{})
+	srv := NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-req.Context().Done()
+		close(aborted)
+	}), ServerOptions{Addr: "127.0.0.1:0", DrainTimeout: 100 * time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Run(ctx) }()
+	for i := 0; srv.Addr() == ""; i++ {
+		if i > 100 {
+			t.Fatal("server not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	go http.Get("http://" + srv.Addr() + "/slow")
+	<-started
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected the drain deadline, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop")
+	}
+	select {
+	case <-aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("active connections should be closed after DrainTimeout")
+	}
+}
+
+func TestServerReadyFunc(t *testing.T) {
+	var notReady error
+	srv := NewServer(http.NotFoundHandler(), ServerOptions{ReadyFunc: func() error { return notReady }})
+	check := func(path string) int {
+		w := NewResponseWriter()
+		req, _ := http.NewRequest("GET", path, nil)
+		srv.ServeHTTP(w, req)
+		return w.StatusCode()
+	}
+	if check("/readyz") != 503 {
+		t.Fatal("should not be ready before running")
+	}
+	srv.SetReady(true)
+	if check("/readyz") != 200 || check("/other") != 404 {
+		t.Fatal("should be ready")
+	}
+	notReady = errors.New("db down")
+	if check("/readyz") != 503 {
+		t.Fatal("ReadyFunc should fail readiness")
+	}
+}
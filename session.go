@@ -0,0 +1,471 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCookieInvalid = errors.New("Invalid cookie value")
+	ErrCookieExpired = errors.New("Cookie expired")
+)
+
+var cookieEncoding = base64.RawURLEncoding
+
+// CookieCodec signs cookie values with HMAC-SHA256, and encrypts them with
+// AES-GCM if given an encryption key of 16, 24 or 32 bytes. The cookie name
+// and a timestamp are authenticated along with the value.
+type CookieCodec struct {
+	hashKey []byte
+	aead    cipher.AEAD
+	MaxAge  time.Duration // 0 for no expiration check
+	Now     func() time.Time
+
+	// Previous codecs, with older keys, still decode values, for key rotation
+	Previous []*CookieCodec
+}
+
+func NewCookieCodec(hashKey, encKey []byte) (*CookieCodec, error) {
+	if len(hashKey) == 0 {
+		return nil, errors.New("Empty hash key")
+	}
+	c := &CookieCodec{hashKey: hashKey}
+	if len(encKey) > 0 {
+		block, err := aes.NewCipher(encKey)
+		if err != nil {
+			return nil, err
+		}
+		if c.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *CookieCodec) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+func (c *CookieCodec) mac(name string, data []byte) []byte {
+	h := hmac.New(sha256.New, c.hashKey)
+	h.Write([]byte(name))
+	h.Write([]byte{'|'})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// Encode marshals value into json before signing and encrypting
+func (c *CookieCodec) Encode(name string, value interface{}) (string, error) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	if c.aead != nil {
+		nonce, err := CrandBytes(c.aead.NonceSize())
+		if err != nil {
+			return "", err
+		}
+		payload = c.aead.Seal(nonce, nonce, payload, []byte(name))
+	}
+
+	data := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint64(data, uint64(c.now().Unix()))
+	data = append(data, payload...)
+
+	return cookieEncoding.EncodeToString(data) + "." +
+		cookieEncoding.EncodeToString(c.mac(name, data)), nil
+}
+
+func (c *CookieCodec) Decode(name, encoded string, ret interface{}) error {
+	err := c.decode(name, encoded, ret)
+	for _, prev := range c.Previous {
+		if err != ErrCookieInvalid {
+			break
+		}
+		err = prev.Decode(name, encoded, ret)
+	}
+	return err
+}
+
+func (c *CookieCodec) decode(name, encoded string, ret interface{}) error {
+	dataStr, macStr := CutHalf(encoded, '.')
+	data, err := cookieEncoding.DecodeString(dataStr)
+	if err != nil || len(data) < 8 {
+		return ErrCookieInvalid
+	}
+	mac, err := cookieEncoding.DecodeString(macStr)
+	if err != nil || !hmac.Equal(mac, c.mac(name, data)) {
+		return ErrCookieInvalid
+	}
+
+	ts := time.Unix(int64(binary.BigEndian.Uint64(data[:8])), 0)
+	if c.MaxAge > 0 && c.now().Sub(ts) > c.MaxAge {
+		return ErrCookieExpired
+	}
+
+	payload := data[8:]
+	if c.aead != nil {
+		ns := c.aead.NonceSize()
+		if len(payload) < ns {
+			return ErrCookieInvalid
+		}
+		if payload, err = c.aead.Open(nil, payload[:ns], payload[ns:], []byte(name)); err != nil {
+			return ErrCookieInvalid
+		}
+	}
+	if err := json.Unmarshal(payload, ret); err != nil {
+		return ErrCookieInvalid
+	}
+	return nil
+}
+
+// SetCookie encodes value into a cookie based on the template cookie, if any
+func (c *CookieCodec) SetCookie(w http.ResponseWriter, name string, value interface{}, template *http.Cookie) error {
+	encoded, err := c.Encode(name, value)
+	if err != nil {
+		return err
+	}
+	cookie := &http.Cookie{Path: "/", HttpOnly: true}
+	if template != nil {
+		*cookie = *template
+	}
+	cookie.Name, cookie.Value = name, encoded
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+func (c *CookieCodec) GetCookie(req *http.Request, name string, ret interface{}) error {
+	cookie, err := req.Cookie(name)
+	if err != nil {
+		return err
+	}
+	return c.Decode(name, cookie.Value, ret)
+}
+
+// sessions
+
+type SessionStore interface {
+	// Load returns nil without error if not found or expired
+	Load(id string) (Map, error)
+	Save(id string, values Map, ttl time.Duration) error
+	Delete(id string) error
+}
+
+type memorySession struct {
+	values    []byte // json, so callers never share maps
+	expiresAt time.Time
+}
+
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]memorySession
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: map[string]memorySession{}}
+}
+
+func (s *MemorySessionStore) Load(id string) (Map, error) {
+	s.mu.Lock()
+	sess, ok := s.sessions[id]
+	if ok && time.Now().After(sess.expiresAt) {
+		delete(s.sessions, id)
+		ok = false
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		return nil, nil
+	}
+	var values Map
+	err := json.Unmarshal(sess.values, &values)
+	return values, err
+}
+
+func (s *MemorySessionStore) Save(id string, values Map, ttl time.Duration) error {
+	buf, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[id] = memorySession{buf, time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemorySessionStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+// Cleanup removes expired sessions, e.g. to be called periodically
+func (s *MemorySessionStore) Cleanup() {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, sess := range s.sessions {
+		if now.After(sess.expiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// Session values are only changed through Set and Delete, which mark the
+// session for saving
+type Session struct {
+	ID string
+
+	values    Map
+	mu        sync.Mutex
+	modified  bool
+	destroyed bool
+	oldID     string
+}
+
+func (s *Session) Get(key string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key]
+}
+
+// Values returns a copy of the session values
+func (s *Session) Values() Map {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make(Map, len(s.values))
+	for k, v := range s.values {
+		ret[k] = v
+	}
+	return ret
+}
+
+func (s *Session) Set(key string, val interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = val
+	s.modified = true
+}
+
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	s.modified = true
+}
+
+// Destroy removes the session from store and client
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = Map{}
+	s.destroyed = true
+}
+
+// Regenerate switches to a new session id keeping the values, e.g. on login
+func (s *Session) Regenerate() error {
+	id, err := newSessionID()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.oldID == "" {
+		s.oldID = s.ID
+	}
+	s.ID = id
+	s.modified = true
+	return nil
+}
+
+func newSessionID() (string, error) {
+	b, err := CrandBytes(32)
+	if err != nil {
+		return "", err
+	}
+	return cookieEncoding.EncodeToString(b), nil
+}
+
+type sessionContextKey struct{}
+
+func SessionFromContext(ctx context.Context) *Session {
+	sess, _ := ctx.Value(sessionContextKey{}).(*Session)
+	return sess
+}
+
+// SessionManager keeps the session id in a signed cookie and session values
+// in the store
+type SessionManager struct {
+	Store      SessionStore
+	Codec      *CookieCodec
+	CookieName string        // default "session"
+	TTL        time.Duration // default 24h
+	Cookie     http.Cookie   // template for Path, Domain, Secure, SameSite etc
+}
+
+func NewSessionManager(store SessionStore, codec *CookieCodec) *SessionManager {
+	return &SessionManager{
+		Store:      store,
+		Codec:      codec,
+		CookieName: "session",
+		TTL:        24 * time.Hour,
+		Cookie:     http.Cookie{Path: "/", HttpOnly: true, SameSite: http.SameSiteLaxMode},
+	}
+}
+
+// Middleware loads the session into the request context, saving it before
+// the response header is written
+func (m *SessionManager) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		sess, err := m.load(req)
+		if err != nil {
+			WriteJsonError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		sw := &sessionWriter{ResponseWriter: w, save: func() error { return m.save(w, sess) }}
+		next.ServeHTTP(sw, req.WithContext(context.WithValue(req.Context(), sessionContextKey{}, sess)))
+		sw.flush()
+	})
+}
+
+func (m *SessionManager) load(req *http.Request) (*Session, error) {
+	var id string
+	if m.Codec.GetCookie(req, m.CookieName, &id) == nil && id != "" {
+		values, err := m.Store.Load(id)
+		if err != nil {
+			return nil, err
+		}
+		if values != nil {
+			return &Session{ID: id, values: values}, nil
+		}
+	}
+
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+	return &Session{ID: id, values: Map{}}, nil
+}
+
+func (m *SessionManager) save(w http.ResponseWriter, sess *Session) error {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if sess.oldID != "" {
+		if err := m.Store.Delete(sess.oldID); err != nil {
+			return err
+		}
+	}
+	if sess.destroyed {
+		cookie := m.Cookie
+		cookie.Name, cookie.Value, cookie.MaxAge = m.CookieName, "", -1
+		http.SetCookie(w, &cookie)
+		return m.Store.Delete(sess.ID)
+	}
+	if !sess.modified {
+		return nil
+	}
+
+	if err := m.Store.Save(sess.ID, sess.values, m.TTL); err != nil {
+		return err
+	}
+	cookie := m.Cookie
+	cookie.MaxAge = int(m.TTL / time.Second)
+	return m.Codec.SetCookie(w, m.CookieName, sess.ID, &cookie)
+}
+
+// sessionWriter saves the session before the header is written, replying
+// 500 instead if that fails
+type sessionWriter struct {
+	http.ResponseWriter
+	save   func() error
+	saved  bool
+	failed bool // later writes are dropped
+}
+
+func (w *sessionWriter) flush() {
+	if !w.saved {
+		w.saved = true
+		if err := w.save(); err != nil {
+			w.fail(err)
+		}
+	}
+}
+
+func (w *sessionWriter) fail(err error) {
+	w.failed = true
+	w.Header().Set("Content-Type", ctAppJson)
+	w.ResponseWriter.WriteHeader(http.StatusInternalServerError)
+	w.ResponseWriter.Write(SimpleJsonError(err.Error(), http.StatusInternalServerError))
+}
+
+func (w *sessionWriter) WriteHeader(statusCode int) {
+	if !w.saved {
+		w.saved = true
+		if err := w.save(); err != nil {
+			w.fail(err)
+			return
+		}
+	}
+	if !w.failed {
+		w.ResponseWriter.WriteHeader(statusCode)
+	}
+}
+
+func (w *sessionWriter) Write(buf []byte) (int, error) {
+	if !w.saved {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.failed {
+		return len(buf), nil
+	}
+	return w.ResponseWriter.Write(buf)
+}
+
+// Flush and Hijack keep streaming and websocket upgrades working behind
+// the middleware
+
+func (w *sessionWriter) Flush() {
+	if !w.saved {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.failed {
+		f.Flush()
+	}
+}
+
+// Hijack saves the session first, though the session cookie cannot be set
+// on a hijacked connection
+func (w *sessionWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("Hijacking not supported")
+	}
+	if !w.saved {
+		w.saved = true
+		if err := w.save(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return hj.Hijack()
+}
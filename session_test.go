@@ -0,0 +1,194 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	neturl "net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCookieCodec(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	clock := func() time.Time { return now }
+	signed, _ := NewCookieCodec([]byte("hash-key"), nil)
+	encrypted, _ := NewCookieCodec([]byte("hash-key"), []byte("0123456789abcdef"))
+
+	for _, c := range []*CookieCodec{signed, encrypted} {
+		c.Now, c.MaxAge = clock, time.Hour
+		encoded, err := c.Encode("sid", Map{"user": "jy"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m Map
+		if err := c.Decode("sid", encoded, &m); err != nil || m["user"] != "jy" {
+			t.Fatalf("bad round trip %v %v", m, err)
+		}
+
+		// tampering with the value, signature or name
+		data, mac := CutHalf(encoded, '.')
+		flip := func(s string) string {
+			b := []byte(s)
+			if b[5] == 'A' {
+				b[5] = 'B'
+			} else {
+				b[5] = 'A'
+			}
+			return string(b)
+		}
+		for _, bad := range []string{flip(data) + "." + mac, data + "." + flip(mac), data, "", "!!." + mac} {
+			if err := c.Decode("sid", bad, &m); err != ErrCookieInvalid {
+				t.Fatalf("%q: expected invalid, got %v", bad, err)
+			}
+		}
+		if err := c.Decode("other", encoded, &m); err != ErrCookieInvalid {
+			t.Fatalf("values should be bound to the cookie name, got %v", err)
+		}
+
+		now = now.Add(2 * time.Hour)
+		if err := c.Decode("sid", encoded, &m); err != ErrCookieExpired {
+			t.Fatalf("expected expired, got %v", err)
+		}
+	}
+
+	// key rotation
+	old, _ := NewCookieCodec([]byte("old-key"), []byte("0123456789abcdef"))
+	encoded, _ := old.Encode("sid", "value")
+	current, _ := NewCookieCodec([]byte("new-key"), []byte("fedcba9876543210"))
+	var v string
+	if err := current.Decode("sid", encoded, &v); err != ErrCookieInvalid {
+		t.Fatalf("expected invalid without previous keys, got %v", err)
+	}
+	current.Previous = []*CookieCodec{old}
+	if err := current.Decode("sid", encoded, &v); err != nil || v != "value" {
+		t.Fatalf("previous keys should decode, got %q %v", v, err)
+	}
+	if encoded, _ = current.Encode("sid", "new"); old.Decode("sid", encoded, &v) == nil {
+		t.Fatal("new values should use the current keys")
+	}
+}
+
+type failingSessionStore struct {
+	*MemorySessionStore
+}
+
+func (s failingSessionStore) Save(id string, values Map, ttl time.Duration) error {
+	return errors.New("store down")
+}
+
+func TestSessionMiddleware(t *testing.T) {
+	codec, _ := NewCookieCodec([]byte("hash-key"), nil)
+	store := NewMemorySessionStore()
+	m := NewSessionManager(store, codec)
+
+	var lastID string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/set", func(w http.ResponseWriter, req *http.Request) {
+		sess := SessionFromContext(req.Context())
+		sess.Set("n", req.URL.Query().Get("n"))
+		lastID = sess.ID
+	})
+	mux.HandleFunc("/get", func(w http.ResponseWriter, req *http.Request) {
+		sess := SessionFromContext(req.Context())
+		lastID = sess.ID
+		n, _ := sess.Get("n").(string)
+		w.Write([]byte(n))
+	})
+	mux.HandleFunc("/regenerate", func(w http.ResponseWriter, req *http.Request) {
+		SessionFromContext(req.Context()).Regenerate()
+	})
+	mux.HandleFunc("/destroy", func(w http.ResponseWriter, req *http.Request) {
+		SessionFromContext(req.Context()).Destroy()
+	})
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, req *http.Request) {
+		SessionFromContext(req.Context()).Set("streamed", true)
+		f, ok := w.(http.Flusher)
+		if !ok {
+			t.Error("flusher hidden by the session writer")
+			return
+		}
+		w.Write([]byte("data: 1\n\n"))
+		f.Flush()
+	})
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, req *http.Request) {
+		conn, err := WsUpgrade(w, req, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.WriteMessage(WsText, []byte("hi"))
+		conn.Close(WsCloseNormal, "")
+	})
+	srv := httptest.NewServer(m.Middleware(mux))
+	defer srv.Close()
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	get := func(path string) (int, string) {
+		res, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ReadResponseBody(res)
+		return res.StatusCode, string(body)
+	}
+
+	u, _ := neturl.Parse(srv.URL)
+	if _, n := get("/get"); n != "" || len(jar.Cookies(u)) != 0 {
+		t.Fatal("unmodified sessions should not be saved")
+	}
+	get("/set?n=1")
+	id := lastID
+	if _, n := get("/get"); n != "1" || lastID != id {
+		t.Fatalf("session not restored, got %q", n)
+	}
+
+	get("/regenerate")
+	if _, n := get("/get"); n != "1" || lastID == id {
+		t.Fatal("regenerate should keep values under a new id")
+	}
+	if values, _ := store.Load(id); values != nil {
+		t.Fatal("the old session should be deleted")
+	}
+
+	if status, body := get("/stream"); status != 200 || body != "data: 1\n\n" {
+		t.Fatalf("bad stream %d %q", status, body)
+	}
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+	conn, _, err := WsDial(context.Background(), url, nil)
+	if err != nil {
+		t.Fatalf("websocket upgrade behind sessions: %v", err)
+	}
+	if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != "hi" {
+		t.Fatalf("bad websocket message %q %v", msg, err)
+	}
+	conn.Close(WsCloseNormal, "")
+
+	get("/destroy")
+	if _, n := get("/get"); n != "" {
+		t.Fatal("destroyed session should be gone")
+	}
+
+	// save errors are reported, not dropped
+	m.Store = failingSessionStore{store}
+	if status, body := get("/set?n=2"); status != 500 || !strings.Contains(body, "store down") {
+		t.Fatalf("expected save error, got %d %s", status, body)
+	}
+	written := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		SessionFromContext(req.Context()).Set("n", 3)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+	w := NewResponseWriter()
+	req, _ := http.NewRequest("POST", "/", nil)
+	written.ServeHTTP(w, req)
+	if w.StatusCode() != 500 || !strings.Contains(w.String(), "store down") {
+		t.Fatalf("expected save error before the header, got %d %s", w.StatusCode(), w.String())
+	}
+}
@@ -0,0 +1,652 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// REF: https://datatracker.ietf.org/doc/html/rfc6455
+// No extensions (e.g. permessage-deflate) are supported.
+
+const (
+	WsContinuation = 0
+	WsText         = 1
+	WsBinary       = 2
+	WsClose        = 8
+	WsPing         = 9
+	WsPong         = 10
+)
+
+const (
+	WsCloseNormal          = 1000
+	WsCloseGoingAway       = 1001
+	WsCloseProtocolError   = 1002
+	WsCloseUnsupportedData = 1003
+	WsCloseNoStatus        = 1005
+	WsCloseAbnormal        = 1006
+	WsCloseInvalidPayload  = 1007
+	WsClosePolicyViolation = 1008
+	WsCloseTooBig          = 1009
+	WsCloseMandatoryExt    = 1010
+	WsCloseInternalError   = 1011
+)
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var ErrWsClosed = errors.New("Websocket closed")
+
+type WsCloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *WsCloseError) Error() string {
+	return fmt.Sprintf("Websocket closed %d %s", e.Code, e.Reason)
+}
+
+func IsWsCloseError(err error, codes ...int) bool {
+	var e *WsCloseError
+	if !errors.As(err, &e) {
+		return false
+	}
+	if len(codes) == 0 {
+		return true
+	}
+	for _, code := range codes {
+		if e.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+type WsOptions struct {
+	CheckOrigin    func(req *http.Request) bool // default: same host if Origin given
+	Subprotocols   []string                     // in order of preference
+	MaxMessageSize int64                        // default 16MB
+	FragmentSize   int                          // default 0, no fragmentation on write
+	Header         http.Header                  // extra handshake headers
+}
+
+type WsConn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	client      bool
+	subprotocol string
+	maxSize     int64
+	fragSize    int
+
+	wmu        sync.Mutex
+	closeSent  bool
+	rmu        sync.Mutex
+	closeRecvd bool
+
+	PingHandler func(data []byte) error // default replies pong
+	PongHandler func(data []byte) error
+}
+
+func newWsConn(conn net.Conn, br *bufio.Reader, client bool, opts *WsOptions) *WsConn {
+	c := &WsConn{conn: conn, br: br, client: client, maxSize: 16 << 20}
+	if opts != nil {
+		if opts.MaxMessageSize > 0 {
+			c.maxSize = opts.MaxMessageSize
+		}
+		c.fragSize = opts.FragmentSize
+	}
+	return c
+}
+
+func wsAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func wsSameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := neturl.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Host)
+}
+
+// WsUpgrade performs the server handshake, replying with an error status if
+// the request is not a valid websocket upgrade
+func WsUpgrade(w http.ResponseWriter, req *http.Request, opts *WsOptions) (*WsConn, error) {
+	if opts == nil {
+		opts = &WsOptions{}
+	}
+	fail := func(status int, msg string) (*WsConn, error) {
+		WriteJsonError(w, status, msg)
+		return nil, errors.New(msg)
+	}
+
+	if req.Method != http.MethodGet {
+		return fail(http.StatusMethodNotAllowed, "Websocket requires GET")
+	}
+	if !headerContainsToken(req.Header, "Connection", "upgrade") ||
+		!headerContainsToken(req.Header, "Upgrade", "websocket") {
+		return fail(http.StatusBadRequest, "Not a websocket upgrade")
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return fail(http.StatusUpgradeRequired, "Unsupported websocket version")
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if kb, err := base64.StdEncoding.DecodeString(key); err != nil || len(kb) != 16 {
+		return fail(http.StatusBadRequest, "Bad Sec-WebSocket-Key")
+	}
+	checkOrigin := opts.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = wsSameOrigin
+	}
+	if !checkOrigin(req) {
+		return fail(http.StatusForbidden, "Origin not allowed")
+	}
+
+	var subprotocol string
+	for _, p := range opts.Subprotocols {
+		if headerContainsToken(req.Header, "Sec-WebSocket-Protocol", p) {
+			subprotocol = p
+			break
+		}
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return fail(http.StatusInternalServerError, "Connection cannot be hijacked")
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	sb.WriteString("Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n")
+	if subprotocol != "" {
+		sb.WriteString("Sec-WebSocket-Protocol: " + subprotocol + "\r\n")
+	}
+	for k, vs := range opts.Header {
+		for _, v := range vs {
+			sb.WriteString(k + ": " + v + "\r\n")
+		}
+	}
+	sb.WriteString("\r\n")
+	if _, err := conn.Write([]byte(sb.String())); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := newWsConn(conn, brw.Reader, false, opts)
+	c.subprotocol = subprotocol
+	return c, nil
+}
+
+// WsDial connects to a ws:// or wss:// url
+func WsDial(ctx context.Context, url string, opts *WsOptions) (*WsConn, *http.Response, error) {
+	if opts == nil {
+		opts = &WsOptions{}
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	secure := false
+	switch u.Scheme {
+	case "ws":
+	case "wss":
+		secure = true
+	default:
+		return nil, nil, fmt.Errorf("Bad websocket scheme %s", u.Scheme)
+	}
+
+	kb := make([]byte, 16)
+	if _, err := rand.Read(kb); err != nil {
+		return nil, nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(kb)
+
+	host := u.Host
+	if u.Port() == "" {
+		if secure {
+			host += ":443"
+		} else {
+			host += ":80"
+		}
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, nil, err
+	}
+	if secure {
+		tconn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tconn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		conn = tconn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	u.Scheme = strings.Replace(u.Scheme, "ws", "http", 1)
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Host:       u.Host,
+	}
+	for k, vs := range opts.Header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if len(opts.Subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(opts.Subprotocols, ", "))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols ||
+		!headerContainsToken(res.Header, "Upgrade", "websocket") ||
+		res.Header.Get("Sec-WebSocket-Accept") != wsAcceptKey(key) {
+		conn.Close()
+		return nil, res, fmt.Errorf("Websocket handshake failed with status %d", res.StatusCode)
+	}
+	// the server may only pick one of the offered subprotocols
+	subprotocol := res.Header.Get("Sec-WebSocket-Protocol")
+	if subprotocol != "" && !ContainsString(opts.Subprotocols, subprotocol) {
+		conn.Close()
+		return nil, res, fmt.Errorf("Websocket subprotocol %q not offered", subprotocol)
+	}
+
+	c := newWsConn(conn, br, true, opts)
+	c.subprotocol = subprotocol
+	return c, res, nil
+}
+
+func (c *WsConn) Subprotocol() string  { return c.subprotocol }
+func (c *WsConn) LocalAddr() net.Addr  { return c.conn.LocalAddr() }
+func (c *WsConn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+func (c *WsConn) SetReadDeadline(t time.Time) error  { return c.conn.SetReadDeadline(t) }
+func (c *WsConn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
+
+// writing
+
+func (c *WsConn) writeFrame(fin bool, opcode int, data []byte) error {
+	header := make([]byte, 2, 14)
+	header[0] = byte(opcode)
+	if fin {
+		header[0] |= 0x80
+	}
+	n := len(data)
+	switch {
+	case n <= 125:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = append(header, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+
+	payload := data
+	if c.client {
+		header[1] |= 0x80
+		mask := make([]byte, 4)
+		if _, err := rand.Read(mask); err != nil {
+			return err
+		}
+		header = append(header, mask...)
+		payload = make([]byte, n)
+		for i := range data {
+			payload[i] = data[i] ^ mask[i%4]
+		}
+	}
+
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+func (c *WsConn) WriteMessage(msgType int, data []byte) error {
+	if msgType != WsText && msgType != WsBinary {
+		return c.writeControl(msgType, data)
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrWsClosed
+	}
+
+	if c.fragSize <= 0 || len(data) <= c.fragSize {
+		return c.writeFrame(true, msgType, data)
+	}
+	opcode := msgType
+	for len(data) > 0 {
+		n := c.fragSize
+		if n > len(data) {
+			n = len(data)
+		}
+		if err := c.writeFrame(n == len(data), opcode, data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+		opcode = WsContinuation
+	}
+	return nil
+}
+
+func (c *WsConn) writeControl(opcode int, data []byte) error {
+	if len(data) > 125 {
+		return errors.New("Control frame payload too large")
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrWsClosed
+	}
+	if opcode == WsClose {
+		c.closeSent = true
+	}
+	return c.writeFrame(true, opcode, data)
+}
+
+func (c *WsConn) Ping(data []byte) error {
+	return c.writeControl(WsPing, data)
+}
+
+func (c *WsConn) WriteClose(code int, reason string) error {
+	var data []byte
+	if code != WsCloseNoStatus {
+		data = make([]byte, 2, 2+len(reason))
+		binary.BigEndian.PutUint16(data, uint16(code))
+		data = append(data, reason...)
+	}
+	return c.writeControl(WsClose, data)
+}
+
+// Close sends a close frame, waiting briefly for the peer to reply, and
+// closes the underlying connection
+func (c *WsConn) Close(code int, reason string) error {
+	err := c.WriteClose(code, reason)
+	if err == nil {
+		c.conn.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			if _, _, rerr := c.ReadMessage(); rerr != nil {
+				break
+			}
+		}
+	}
+	if cerr := c.conn.Close(); err == nil || errors.Is(err, ErrWsClosed) {
+		err = cerr
+	}
+	return err
+}
+
+func (c *WsConn) WriteText(text string) error {
+	return c.WriteMessage(WsText, []byte(text))
+}
+
+func (c *WsConn) WriteJson(v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(WsText, buf)
+}
+
+// reading
+
+type wsFrame struct {
+	fin    bool
+	opcode int
+	data   []byte
+}
+
+func (c *WsConn) readFrame() (*wsFrame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return nil, err
+	}
+	f := &wsFrame{fin: head[0]&0x80 != 0, opcode: int(head[0] & 0x0f)}
+	if head[0]&0x70 != 0 {
+		return nil, c.protocolError(WsCloseProtocolError, "Reserved bits set")
+	}
+	masked := head[1]&0x80 != 0
+	if masked == c.client {
+		return nil, c.protocolError(WsCloseProtocolError, "Bad frame masking")
+	}
+
+	n := int64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return nil, err
+		}
+		n = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return nil, err
+		}
+		if ext[0]&0x80 != 0 {
+			return nil, c.protocolError(WsCloseProtocolError, "Bad frame length")
+		}
+		n = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+
+	if f.opcode >= WsClose {
+		if n > 125 || !f.fin {
+			return nil, c.protocolError(WsCloseProtocolError, "Bad control frame")
+		}
+	} else if n > c.maxSize {
+		return nil, c.protocolError(WsCloseTooBig, "Message too big")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return nil, err
+		}
+	}
+	f.data = make([]byte, n)
+	if _, err := io.ReadFull(c.br, f.data); err != nil {
+		return nil, err
+	}
+	if masked {
+		for i := range f.data {
+			f.data[i] ^= mask[i%4]
+		}
+	}
+	return f, nil
+}
+
+func (c *WsConn) protocolError(code int, msg string) error {
+	c.WriteClose(code, msg)
+	return &WsCloseError{code, msg}
+}
+
+// ReadMessage returns the next text or binary message, reassembling
+// fragments and handling control frames in between. A close from the peer
+// is answered and returned as *WsCloseError.
+func (c *WsConn) ReadMessage() (int, []byte, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if c.closeRecvd {
+		return 0, nil, ErrWsClosed
+	}
+
+	msgType := 0
+	var msg []byte
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch f.opcode {
+		case WsPing:
+			h := c.PingHandler
+			if h == nil {
+				h = func(data []byte) error {
+					if err := c.writeControl(WsPong, data); err != ErrWsClosed {
+						return err
+					}
+					return nil
+				}
+			}
+			if err := h(f.data); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case WsPong:
+			if c.PongHandler != nil {
+				if err := c.PongHandler(f.data); err != nil {
+					return 0, nil, err
+				}
+			}
+			continue
+		case WsClose:
+			c.closeRecvd = true
+			return 0, nil, c.handleClose(f.data)
+		case WsText, WsBinary:
+			if msgType != 0 {
+				return 0, nil, c.protocolError(WsCloseProtocolError, "Expected continuation frame")
+			}
+			msgType = f.opcode
+			msg = f.data
+		case WsContinuation:
+			if msgType == 0 {
+				return 0, nil, c.protocolError(WsCloseProtocolError, "Unexpected continuation frame")
+			}
+			if int64(len(msg)+len(f.data)) > c.maxSize {
+				return 0, nil, c.protocolError(WsCloseTooBig, "Message too big")
+			}
+			msg = append(msg, f.data...)
+		default:
+			return 0, nil, c.protocolError(WsCloseProtocolError, "Unknown opcode")
+		}
+
+		if f.fin {
+			if msgType == WsText && !utf8.Valid(msg) {
+				return 0, nil, c.protocolError(WsCloseInvalidPayload, "Invalid UTF-8 text")
+			}
+			return msgType, msg, nil
+		}
+	}
+}
+
+func (c *WsConn) handleClose(data []byte) error {
+	code, reason := WsCloseNoStatus, ""
+	switch {
+	case len(data) == 1:
+		return c.protocolError(WsCloseProtocolError, "Bad close frame")
+	case len(data) >= 2:
+		code = int(binary.BigEndian.Uint16(data))
+		reason = string(data[2:])
+		if !utf8.ValidString(reason) {
+			return c.protocolError(WsCloseInvalidPayload, "Invalid close reason")
+		}
+		if !wsValidCloseCode(code) {
+			return c.protocolError(WsCloseProtocolError, "Invalid close code")
+		}
+	}
+	// echo the code as the closing handshake, unless we started it
+	echo := code
+	if echo == WsCloseNoStatus {
+		echo = WsCloseNormal
+	}
+	c.WriteClose(echo, "")
+	return &WsCloseError{code, reason}
+}
+
+func wsValidCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code >= 1000 && code <= 1011:
+		return code != 1004 && code != WsCloseNoStatus && code != WsCloseAbnormal
+	}
+	return false
+}
+
+func (c *WsConn) ReadText() (string, error) {
+	for {
+		msgType, data, err := c.ReadMessage()
+		if err != nil {
+			return "", err
+		}
+		if msgType == WsText {
+			return string(data), nil
+		}
+	}
+}
+
+func (c *WsConn) ReadJson(v interface{}) error {
+	_, data, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
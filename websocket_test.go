@@ -0,0 +1,99 @@
+// Copyright (c) 2021 Jing-Ying Chen. Subject to the MIT License.
+
+package goutil
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebsocketEcho(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := WsUpgrade(w, req, &WsOptions{Subprotocols: []string{"echo"}})
+		if err != nil {
+			return
+		}
+		for {
+			msgType, data, err := conn.ReadMessage()
+			if err != nil {
+				conn.Close(WsCloseNormal, "")
+				return
+			}
+			conn.WriteMessage(msgType, data)
+		}
+	}))
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	conn, _, err := WsDial(context.Background(), url, &WsOptions{Subprotocols: []string{"echo"}, FragmentSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.Subprotocol() != "echo" {
+		t.Fatalf("bad subprotocol %q", conn.Subprotocol())
+	}
+
+	pongs := 0
+	conn.PongHandler = func([]byte) error { pongs++; return nil }
+	if err := conn.Ping([]byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn.WriteText("hello, fragmented world"); err != nil {
+		t.Fatal(err)
+	}
+	if text, err := conn.ReadText(); err != nil || text != "hello, fragmented world" {
+		t.Fatalf("bad echo %q %v", text, err)
+	}
+	if pongs != 1 {
+		t.Fatalf("expected 1 pong, got %d", pongs)
+	}
+
+	if err := conn.WriteJson(Map{"a": 1.0}); err != nil {
+		t.Fatal(err)
+	}
+	var m Map
+	if err := conn.ReadJson(&m); err != nil || m["a"] != 1.0 {
+		t.Fatalf("bad json echo %v %v", m, err)
+	}
+
+	if err := conn.WriteClose(WsCloseGoingAway, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := conn.ReadMessage(); !IsWsCloseError(err, WsCloseGoingAway) {
+		t.Fatalf("expected close echo, got %v", err)
+	}
+}
+
+func TestWebsocketUpgradeRejects(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	w := NewResponseWriter()
+	if _, err := WsUpgrade(w, req, nil); err == nil || w.StatusCode() != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.StatusCode())
+	}
+	if wsAcceptKey("dGhlIHNhbXBsZSBub25jZQ==") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatal("bad accept key")
+	}
+}
+
+func TestWebsocketDialRejectsSubprotocol(t *testing.T) {
+	// a server replying with a subprotocol the client never offered
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header := http.Header{"Sec-WebSocket-Protocol": {"other"}}
+		if conn, err := WsUpgrade(w, req, &WsOptions{Header: header}); err == nil {
+			conn.ReadMessage()
+		}
+	}))
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	for _, offered := range [][]string{nil, {"echo"}} {
+		if _, _, err := WsDial(context.Background(), url, &WsOptions{Subprotocols: offered}); err == nil ||
+			!strings.Contains(err.Error(), "not offered") {
+			t.Fatalf("%v: expected subprotocol error, got %v", offered, err)
+		}
+	}
+}